@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/pac-annotations-mapper/health"
+	"github.com/Financial-Times/pac-annotations-mapper/service"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const (
+	invalidConfigExit  = "exit"
+	invalidConfigPause = "pause"
+)
+
+// settings are the app options which are validated on startup.
+type settings struct {
+	onInvalidConfig          string
+	whitelistRegex           string
+	filterConfig             string
+	predicatesConfig         string
+	predicatesReloadInterval int
+	routingConfig            string
+	annotationConflictPolicy string
+	unmappableEventPolicy    string
+	staleEventPolicy         string
+	staleEventCacheSize      int
+	staleEventStore          string
+	deltaMode                string
+	deltaTopic               string
+	deltaCacheSize           int
+	deadLetterTopic          string
+	messageTimeout           int
+	producerMaxAttempts      int
+	producerRetryBackoff     int
+	producerMaxRetryBackoff  int
+	shutdownTimeout          int
+	otlpEndpoint             string
+	otlpInsecure             bool
+}
+
+// validConfig is what the settings are turned into once they are all valid.
+type validConfig struct {
+	whitelist        *regexp.Regexp
+	originFilter     *service.OriginFilter
+	predicates       *service.PredicateMapping
+	routes           []service.RouteConfig
+	routePredicates  map[string]*service.PredicateMapping
+	conflictPolicy   service.ConflictPolicy
+	unmappablePolicy service.UnmappablePolicy
+	stalePolicy      service.StalePolicy
+	timestamps       *service.TimestampTracker
+	deltaMode        service.DeltaMode
+	history          *service.AnnotationHistory
+	tracerProvider   *sdktrace.TracerProvider
+	closers          []io.Closer
+	log              *logger.UPPLogger
+}
+
+// Close releases the files opened while validating the settings and flushes the traces.
+func (c *validConfig) Close() {
+	for _, closer := range c.closers {
+		closer.Close()
+	}
+
+	if c.tracerProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.tracerProvider.Shutdown(ctx); err != nil {
+		c.log.WithError(err).Error("Error flushing traces")
+	}
+}
+
+// validateConfig checks every setting before anything is started, so that all the invalid ones are reported together
+// instead of the service stopping at the first one, or running with a setting which makes it skip every message.
+func validateConfig(s settings, log *logger.UPPLogger) (*validConfig, []health.ConfigError) {
+	c, configErrors := validateMappingConfig(s, log)
+	invalid := func(setting string, err error) {
+		configErrors = append(configErrors, health.ConfigError{Setting: setting, Err: err})
+	}
+
+	if s.onInvalidConfig != invalidConfigExit && s.onInvalidConfig != invalidConfigPause {
+		invalid("onInvalidConfig", fmt.Errorf("unknown action %q, expected %s or %s", s.onInvalidConfig, invalidConfigExit, invalidConfigPause))
+	}
+
+	if s.predicatesReloadInterval < 1 {
+		invalid("predicatesReloadInterval", errors.New("must be at least 1 second"))
+	}
+
+	var err error
+	if c.stalePolicy, err = service.ParseStalePolicy(s.staleEventPolicy); err != nil {
+		invalid("staleEventPolicy", err)
+	}
+	if s.staleEventCacheSize < 1 {
+		invalid("staleEventCacheSize", errors.New("must be at least 1"))
+	} else if c.stalePolicy == service.StalePolicySkip || c.stalePolicy == service.StalePolicyFlag {
+		var store service.TimestampStore
+		if s.staleEventStore != "" {
+			fileStore, err := service.OpenFileTimestampStore(s.staleEventStore)
+			if err != nil {
+				invalid("staleEventStore", err)
+			} else {
+				c.closers = append(c.closers, fileStore)
+				store = fileStore
+			}
+		}
+		// the store is read by the tracker, so its errors are reported against the store
+		if c.timestamps, err = service.NewTimestampTracker(s.staleEventCacheSize, store); err != nil {
+			invalid("staleEventStore", err)
+		}
+	}
+
+	if c.deltaMode, err = service.ParseDeltaMode(s.deltaMode); err != nil {
+		invalid("deltaMode", err)
+	}
+	if c.deltaMode == service.DeltaModeTopic && s.deltaTopic == "" {
+		invalid("deltaTopic", errors.New("the topic delta mode requires the deltaTopic"))
+	}
+	if c.deltaMode == service.DeltaModeTopic || c.deltaMode == service.DeltaModeField {
+		if c.history, err = service.NewAnnotationHistory(s.deltaCacheSize); err != nil {
+			invalid("deltaCacheSize", err)
+		}
+	}
+
+	if s.shutdownTimeout < 1 {
+		invalid("shutdownTimeout", errors.New("must be at least 1 second"))
+	}
+
+	if s.otlpEndpoint != "" {
+		if c.tracerProvider, err = newTracerProvider(s.otlpEndpoint, s.otlpInsecure); err != nil {
+			invalid("otlpEndpoint", err)
+		}
+	}
+
+	return c, configErrors
+}
+
+// validateMappingConfig checks the settings of how the messages are filtered, mapped and sent, which are shared
+// by the service and the replay command.
+func validateMappingConfig(s settings, log *logger.UPPLogger) (*validConfig, []health.ConfigError) {
+	c := &validConfig{routePredicates: map[string]*service.PredicateMapping{}, log: log}
+	var configErrors []health.ConfigError
+	invalid := func(setting string, err error) {
+		configErrors = append(configErrors, health.ConfigError{Setting: setting, Err: err})
+	}
+
+	var err error
+	if c.whitelist, err = regexp.Compile(s.whitelistRegex); err != nil {
+		invalid("whitelistRegex", err)
+	}
+
+	if s.filterConfig != "" {
+		if c.originFilter, err = loadOriginFilter(s.filterConfig); err != nil {
+			invalid("filterConfig", err)
+		}
+	}
+
+	c.predicates = service.DefaultPredicateMapping()
+	if s.predicatesConfig != "" {
+		if c.predicates, err = service.LoadPredicateMapping(s.predicatesConfig, log); err != nil {
+			invalid("predicatesConfig", err)
+		}
+	}
+
+	if s.routingConfig != "" {
+		if c.routes, err = service.LoadRoutingConfig(s.routingConfig); err != nil {
+			invalid("routingConfig", err)
+		}
+		for _, rc := range c.routes {
+			if rc.PredicatesConfig == "" {
+				continue
+			}
+			if c.routePredicates[rc.Name], err = service.LoadPredicateMapping(rc.PredicatesConfig, log); err != nil {
+				invalid("routingConfig", fmt.Errorf("route %q has an invalid predicate mapping: %w", rc.Name, err))
+			}
+		}
+	}
+
+	if c.conflictPolicy, err = service.ParseConflictPolicy(s.annotationConflictPolicy); err != nil {
+		invalid("annotationConflictPolicy", err)
+	}
+
+	if c.unmappablePolicy, err = service.ParseUnmappablePolicy(s.unmappableEventPolicy); err != nil {
+		invalid("unmappableEventPolicy", err)
+	}
+	if c.unmappablePolicy == service.UnmappablePolicyDeadLetter && s.deadLetterTopic == "" {
+		invalid("unmappableEventPolicy", errors.New("the deadLetter policy requires the deadLetterTopic"))
+	}
+
+	if s.messageTimeout < 0 {
+		invalid("messageTimeout", errors.New("must not be negative"))
+	}
+	if s.producerMaxAttempts < 1 {
+		invalid("producerMaxAttempts", errors.New("must be at least 1"))
+	}
+	if s.producerRetryBackoff < 0 {
+		invalid("producerRetryBackoff", errors.New("must not be negative"))
+	}
+	if s.producerMaxRetryBackoff < s.producerRetryBackoff {
+		invalid("producerMaxRetryBackoff", errors.New("must not be lower than producerRetryBackoff"))
+	}
+
+	return c, configErrors
+}
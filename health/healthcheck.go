@@ -1,7 +1,11 @@
 package health
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"sync/atomic"
 
 	"github.com/Financial-Times/kafka-client-go/v3"
 
@@ -11,6 +15,14 @@ import (
 
 const HealthPath = "/__health"
 
+var errShuttingDown = errors.New("service is shutting down")
+
+// ConfigError is an invalid configuration setting found on startup.
+type ConfigError struct {
+	Setting string
+	Err     error
+}
+
 type kafkaConsumer interface {
 	ConnectivityCheck() error
 	MonitorCheck() error
@@ -24,22 +36,35 @@ type HealthCheck struct {
 	appSystemCode  string
 	appName        string
 	appDescription string
-	whitelistError error
+	configErrors   []ConfigError
 	consumer       kafkaConsumer
 	producer       kafkaProducer
+	shuttingDown   *int32
 }
 
-func NewHealthCheck(appSystemCode string, appName string, appDescription string, whitelistErr error, c kafkaConsumer, p kafkaProducer) *HealthCheck {
+// NewHealthCheck creates the health checks of the service. Every configuration error is reported as a failing check.
+// The consumer and producer are nil when the service does not consume messages because of the configuration errors.
+func NewHealthCheck(appSystemCode string, appName string, appDescription string, configErrors []ConfigError, c kafkaConsumer, p kafkaProducer) *HealthCheck {
 	return &HealthCheck{
 		appSystemCode:  appSystemCode,
 		appName:        appName,
 		appDescription: appDescription,
-		whitelistError: whitelistErr,
+		configErrors:   configErrors,
 		consumer:       c,
 		producer:       p,
+		shuttingDown:   new(int32),
 	}
 }
 
+// SetShuttingDown makes the service report as not good to go, so that no more traffic is routed to it.
+func (h *HealthCheck) SetShuttingDown() {
+	atomic.StoreInt32(h.shuttingDown, 1)
+}
+
+func (h *HealthCheck) isShuttingDown() bool {
+	return atomic.LoadInt32(h.shuttingDown) == 1
+}
+
 func (h *HealthCheck) Health() func(w http.ResponseWriter, r *http.Request) {
 	hc := fthealth.HealthCheck{
 		SystemCode:  h.appSystemCode,
@@ -52,23 +77,31 @@ func (h *HealthCheck) Health() func(w http.ResponseWriter, r *http.Request) {
 
 func (h *HealthCheck) Checks() []fthealth.Check {
 	var checks []fthealth.Check
-	if h.whitelistError != nil {
-		checks = append(checks, h.whitelistCheck())
+	for _, configErr := range h.configErrors {
+		checks = append(checks, configCheck(configErr))
+	}
+	if h.consumer != nil {
+		checks = append(checks, h.readQueueCheck())
+	}
+	if h.producer != nil {
+		checks = append(checks, h.writeQueueCheck())
+	}
+	if h.consumer != nil {
+		checks = append(checks, h.kafkaConsumerMonitoringCheck())
 	}
-	checks = append(checks, h.readQueueCheck(), h.writeQueueCheck(), h.kafkaConsumerMonitoringCheck())
 	return checks
 }
 
-func (h *HealthCheck) whitelistCheck() fthealth.Check {
+func configCheck(configErr ConfigError) fthealth.Check {
 	return fthealth.Check{
-		ID:               "message-whitelist",
-		Name:             "Message Whitelist Filter",
-		Severity:         2,
+		ID:               "config-" + configErr.Setting,
+		Name:             fmt.Sprintf("Configuration setting %s", configErr.Setting),
+		Severity:         1,
 		BusinessImpact:   "No metadata will be mapped to UPP. This will negatively impact metadata availability.",
-		TechnicalSummary: "The whitelist configuration for this mapper is invalid",
+		TechnicalSummary: fmt.Sprintf("The %s setting is invalid, so no messages are consumed. Fix the setting and restart the service.", configErr.Setting),
 		PanicGuide:       "https://runbooks.in.ft.com/pac-annotations-mapper",
 		Checker: func() (string, error) {
-			return "Whitelist regex is invalid", h.whitelistError
+			return fmt.Sprintf("The %s setting is invalid", configErr.Setting), configErr.Err
 		},
 	}
 }
@@ -110,6 +143,17 @@ func (h *HealthCheck) kafkaConsumerMonitoringCheck() fthealth.Check {
 }
 
 func (h *HealthCheck) GTG() gtg.Status {
+	if h.isShuttingDown() {
+		return gtg.Status{GoodToGo: false, Message: errShuttingDown.Error()}
+	}
+	if len(h.configErrors) > 0 {
+		settings := make([]string, 0, len(h.configErrors))
+		for _, configErr := range h.configErrors {
+			settings = append(settings, configErr.Setting)
+		}
+		return gtg.Status{GoodToGo: false, Message: "invalid configuration settings: " + strings.Join(settings, ", ")}
+	}
+
 	consumerCheck := func() gtg.Status {
 		return gtgCheck(h.checkKafkaConsumerConnectivity)
 	}
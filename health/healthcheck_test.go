@@ -41,7 +41,7 @@ func TestHappyHealthCheck(t *testing.T) {
 }
 
 func TestHealthCheckWithUnhappyConsumer(t *testing.T) {
-	hc := HealthCheck{"test-system-code", "test-app-name", "test-app-desc", nil, mockConsumer{errors.New("Error connecting to the queue")}, mockProducer{}}
+	hc := NewHealthCheck("test-system-code", "test-app-name", "test-app-desc", nil, mockConsumer{errors.New("Error connecting to the queue")}, mockProducer{})
 
 	req := httptest.NewRequest("GET", "http://example.com/__health", nil)
 	w := httptest.NewRecorder()
@@ -53,7 +53,7 @@ func TestHealthCheckWithUnhappyConsumer(t *testing.T) {
 }
 
 func TestHealthCheckWithLaggingConsumer(t *testing.T) {
-	hc := HealthCheck{"test-system-code", "test-app-name", "test-app-desc", nil, mockConsumer{errors.New("consumer is lagging")}, mockProducer{}}
+	hc := NewHealthCheck("test-system-code", "test-app-name", "test-app-desc", nil, mockConsumer{errors.New("consumer is lagging")}, mockProducer{})
 
 	req := httptest.NewRequest("GET", "http://example.com/__health", nil)
 	w := httptest.NewRecorder()
@@ -65,7 +65,7 @@ func TestHealthCheckWithLaggingConsumer(t *testing.T) {
 }
 
 func TestHealthCheckWithUnhappyProducer(t *testing.T) {
-	hc := HealthCheck{"test-system-code", "test-app-name", "test-app-desc", nil, mockConsumer{nil}, mockProducer{errors.New("Error connecting to the queue")}}
+	hc := NewHealthCheck("test-system-code", "test-app-name", "test-app-desc", nil, mockConsumer{nil}, mockProducer{errors.New("Error connecting to the queue")})
 
 	req := httptest.NewRequest("GET", "http://example.com/__health", nil)
 	w := httptest.NewRecorder()
@@ -76,9 +76,12 @@ func TestHealthCheckWithUnhappyProducer(t *testing.T) {
 	assert.Contains(t, w.Body.String(), `"name":"Write Message Queue Reachable","ok":false`, "Write message queue healthcheck should be unhappy")
 }
 
-func TestHealthCheckWithWhitelistError(t *testing.T) {
-	whitelistErr := errors.New("test error")
-	hc := NewHealthCheck("test-system-code", "test-app-name", "test-app-desc", whitelistErr, mockConsumer{nil}, mockProducer{})
+func TestHealthCheckWithConfigErrors(t *testing.T) {
+	configErrors := []ConfigError{
+		{Setting: "whitelistRegex", Err: errors.New("missing closing )")},
+		{Setting: "producerMaxAttempts", Err: errors.New("must be at least 1")},
+	}
+	hc := NewHealthCheck("test-system-code", "test-app-name", "test-app-desc", configErrors, nil, nil)
 
 	req := httptest.NewRequest("GET", "http://example.com/__health", nil)
 	w := httptest.NewRecorder()
@@ -86,11 +89,14 @@ func TestHealthCheckWithWhitelistError(t *testing.T) {
 	hc.Health()(w, req)
 
 	assert.Equal(t, 200, w.Code, "It should return HTTP 200 OK")
-	assert.Contains(t, w.Body.String(), `"name":"Message Whitelist Filter","ok":false`, "Whitelist healthcheck should be unhappy")
+	assert.Contains(t, w.Body.String(), `"name":"Configuration setting whitelistRegex","ok":false`, "whitelistRegex healthcheck should be unhappy")
+	assert.Contains(t, w.Body.String(), `missing closing )`)
+	assert.Contains(t, w.Body.String(), `"name":"Configuration setting producerMaxAttempts","ok":false`, "producerMaxAttempts healthcheck should be unhappy")
+	assert.NotContains(t, w.Body.String(), "Read Message Queue Reachable", "Kafka is not checked when messages are not consumed")
 }
 
 func TestGTGHappyFlow(t *testing.T) {
-	hc := HealthCheck{"test-system-code", "test-app-name", "test-app-desc", nil, mockConsumer{nil}, mockProducer{}}
+	hc := NewHealthCheck("test-system-code", "test-app-name", "test-app-desc", nil, mockConsumer{nil}, mockProducer{})
 
 	status := hc.GTG()
 	assert.True(t, status.GoodToGo)
@@ -98,7 +104,7 @@ func TestGTGHappyFlow(t *testing.T) {
 }
 
 func TestGTGBrokenConsumer(t *testing.T) {
-	hc := HealthCheck{"test-system-code", "test-app-name", "test-app-desc", nil, mockConsumer{errors.New("Error connecting to the queue")}, mockProducer{}}
+	hc := NewHealthCheck("test-system-code", "test-app-name", "test-app-desc", nil, mockConsumer{errors.New("Error connecting to the queue")}, mockProducer{})
 
 	status := hc.GTG()
 	assert.False(t, status.GoodToGo)
@@ -106,9 +112,27 @@ func TestGTGBrokenConsumer(t *testing.T) {
 }
 
 func TestGTGBrokenProducer(t *testing.T) {
-	hc := HealthCheck{"test-system-code", "test-app-name", "test-app-desc", nil, mockConsumer{}, mockProducer{errors.New("Error connecting to the queue")}}
+	hc := NewHealthCheck("test-system-code", "test-app-name", "test-app-desc", nil, mockConsumer{}, mockProducer{errors.New("Error connecting to the queue")})
 
 	status := hc.GTG()
 	assert.False(t, status.GoodToGo)
 	assert.Equal(t, "Error connecting to the queue", status.Message)
 }
+
+func TestGTGWithConfigErrors(t *testing.T) {
+	hc := NewHealthCheck("test-system-code", "test-app-name", "test-app-desc", []ConfigError{{Setting: "whitelistRegex", Err: errors.New("test error")}}, nil, nil)
+
+	status := hc.GTG()
+	assert.False(t, status.GoodToGo)
+	assert.Equal(t, "invalid configuration settings: whitelistRegex", status.Message)
+}
+
+func TestGTGShuttingDown(t *testing.T) {
+	hc := NewHealthCheck("test-system-code", "test-app-name", "test-app-desc", nil, mockConsumer{}, mockProducer{})
+
+	hc.SetShuttingDown()
+
+	status := hc.GTG()
+	assert.False(t, status.GoodToGo)
+	assert.Equal(t, "service is shutting down", status.Message)
+}
@@ -1,11 +1,12 @@
 package main
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
 	"regexp"
-	"sync"
 	"syscall"
 	"time"
 
@@ -15,13 +16,25 @@ import (
 	"github.com/Financial-Times/pac-annotations-mapper/health"
 	"github.com/Financial-Times/pac-annotations-mapper/service"
 	status "github.com/Financial-Times/service-status-go/httphandlers"
+	"github.com/Shopify/sarama"
 	cli "github.com/jawher/mow.cli"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const (
 	appName        = "PAC Annotations Mapper"
 	appDescription = "UPP mapper for PAC annotations"
 	appSystemCode  = "pac-annotations-mapper"
+	metricsPath    = "/metrics"
+
+	cancelledMessagesGracePeriod = 5 * time.Second
+	// serverShutdownTimeout is given to the in-flight HTTP requests to complete on shutdown.
+	serverShutdownTimeout = 5 * time.Second
+	// producerAttemptsPerSend is the number of attempts of the Kafka producer to send a message when the message
+	// timeout is set.
+	producerAttemptsPerSend = 3
+	// minProducerNetworkTimeout keeps the Kafka producer working with very short message timeouts.
+	minProducerNetworkTimeout = 100 * time.Millisecond
 )
 
 func main() {
@@ -39,6 +52,12 @@ func main() {
 		Desc:   "Log level",
 		EnvVar: "LOG_LEVEL",
 	})
+	shutdownTimeout := app.Int(cli.IntOpt{
+		Name:   "shutdownTimeout",
+		Value:  25,
+		Desc:   "Seconds to wait on shutdown for the messages being handled to be processed",
+		EnvVar: "SHUTDOWN_TIMEOUT",
+	})
 
 	kafkaAddress := app.String(cli.StringOpt{
 		Name:   "kafkaAddress",
@@ -72,27 +91,293 @@ func main() {
 		EnvVar: "WHITELIST_REGEX",
 		Value:  `http://cmdb\.ft\.com/systems/pac`,
 	})
+	filterConfig := app.String(cli.StringOpt{
+		Name:   "filterConfig",
+		Value:  "",
+		Desc:   "Path to a YAML or JSON file with allow and deny rules for the Origin-System-Id, besides the whitelist",
+		EnvVar: "FILTER_CONFIG",
+	})
+	predicatesConfig := app.String(cli.StringOpt{
+		Name:   "predicatesConfig",
+		Value:  "",
+		Desc:   "Path to a YAML or JSON file mapping PAC predicate URIs to UPP predicates. The built-in mapping is used if empty",
+		EnvVar: "PREDICATES_CONFIG",
+	})
+	predicatesReloadInterval := app.Int(cli.IntOpt{
+		Name:   "predicatesReloadInterval",
+		Value:  30,
+		Desc:   "Interval in seconds for checking the predicate mapping file for changes",
+		EnvVar: "PREDICATES_RELOAD_INTERVAL",
+	})
+	annotationConflictPolicy := app.String(cli.StringOpt{
+		Name:   "annotationConflictPolicy",
+		Value:  string(service.ConflictPolicyKeepAll),
+		Desc:   "How to resolve annotations of the same concept with more than one of the conflicting predicates: keepAll, precedence (keep the first listed predicate) or dropAll",
+		EnvVar: "ANNOTATION_CONFLICT_POLICY",
+	})
+	conflictingPredicates := app.Strings(cli.StringsOpt{
+		Name:   "conflictingPredicates",
+		Value:  []string{"about", "mentions"},
+		Desc:   "UPP predicates which conflict when annotating the same concept, in order of precedence",
+		EnvVar: "CONFLICTING_PREDICATES",
+	})
+	routingConfig := app.String(cli.StringOpt{
+		Name:   "routingConfig",
+		Value:  "",
+		Desc:   "Path to a YAML or JSON file routing messages to topics by Origin-System-Id. Routes take precedence over the whitelist and producer topic",
+		EnvVar: "ROUTING_CONFIG",
+	})
 	producerTopic := app.String(cli.StringOpt{
 		Name:   "producerTopic",
 		Value:  "ConceptAnnotations",
 		Desc:   "The topic to write the concept annotation to",
 		EnvVar: "PRODUCER_TOPIC",
 	})
+	producerMaxAttempts := app.Int(cli.IntOpt{
+		Name:   "producerMaxAttempts",
+		Value:  3,
+		Desc:   "How many times to try sending the mapped annotations before giving up",
+		EnvVar: "PRODUCER_MAX_ATTEMPTS",
+	})
+	producerRetryBackoff := app.Int(cli.IntOpt{
+		Name:   "producerRetryBackoff",
+		Value:  200,
+		Desc:   "Initial wait in milliseconds before retrying to send the mapped annotations. It doubles after every failed attempt",
+		EnvVar: "PRODUCER_RETRY_BACKOFF",
+	})
+	producerMaxRetryBackoff := app.Int(cli.IntOpt{
+		Name:   "producerMaxRetryBackoff",
+		Value:  5000,
+		Desc:   "Maximum wait in milliseconds between attempts to send the mapped annotations",
+		EnvVar: "PRODUCER_MAX_RETRY_BACKOFF",
+	})
+	unmappableEventPolicy := app.String(cli.StringOpt{
+		Name:   "unmappableEventPolicy",
+		Value:  string(service.UnmappablePolicyEmit),
+		Desc:   "What to do with events with annotations none of which can be mapped: emit (with no annotations), skip or deadLetter",
+		EnvVar: "UNMAPPABLE_EVENT_POLICY",
+	})
+	staleEventPolicy := app.String(cli.StringOpt{
+		Name:   "staleEventPolicy",
+		Value:  string(service.StalePolicyOff),
+		Desc:   "What to do with events older than the last event emitted for the same content: off, skip or flag (emit with the Stale-Event header)",
+		EnvVar: "STALE_EVENT_POLICY",
+	})
+	staleEventCacheSize := app.Int(cli.IntOpt{
+		Name:   "staleEventCacheSize",
+		Value:  100000,
+		Desc:   "Number of content UUIDs whose last emitted timestamp is kept in memory",
+		EnvVar: "STALE_EVENT_CACHE_SIZE",
+	})
+	staleEventStore := app.String(cli.StringOpt{
+		Name:   "staleEventStore",
+		Value:  "",
+		Desc:   "Path to a file persisting the last emitted timestamp of every content UUID across restarts. Only kept in memory if empty",
+		EnvVar: "STALE_EVENT_STORE",
+	})
+	deltaMode := app.String(cli.StringOpt{
+		Name:   "deltaMode",
+		Value:  string(service.DeltaModeOff),
+		Desc:   "How to emit the annotations added and removed since the last annotations emitted for the same content: off, topic (to deltaTopic) or field (in the delta field)",
+		EnvVar: "DELTA_MODE",
+	})
+	deltaTopic := app.String(cli.StringOpt{
+		Name:   "deltaTopic",
+		Value:  "",
+		Desc:   "The topic to write the annotation deltas to in the topic delta mode",
+		EnvVar: "DELTA_TOPIC",
+	})
+	deltaCacheSize := app.Int(cli.IntOpt{
+		Name:   "deltaCacheSize",
+		Value:  100000,
+		Desc:   "Number of content UUIDs whose last emitted annotations are kept in memory to compute the deltas",
+		EnvVar: "DELTA_CACHE_SIZE",
+	})
+	messageTimeout := app.Int(cli.IntOpt{
+		Name:   "messageTimeout",
+		Value:  30000,
+		Desc:   "Maximum time in milliseconds to spend handling a single message, including producer retries. Disabled if 0",
+		EnvVar: "MESSAGE_TIMEOUT",
+	})
+	passThroughHeaders := app.Strings(cli.StringsOpt{
+		Name:   "passThroughHeaders",
+		Value:  []string{},
+		Desc:   "Headers of the consumed messages to copy to the mapped annotations messages",
+		EnvVar: "PASS_THROUGH_HEADERS",
+	})
+	auditTopic := app.String(cli.StringOpt{
+		Name:   "auditTopic",
+		Value:  "",
+		Desc:   "The topic to publish the mapping report of every event passing the filter to. Reports are only logged if empty",
+		EnvVar: "AUDIT_TOPIC",
+	})
+	otlpEndpoint := app.String(cli.StringOpt{
+		Name:   "otlpEndpoint",
+		Value:  "",
+		Desc:   "host:port of the OTLP HTTP collector to export traces to. Tracing is disabled if empty",
+		EnvVar: "OTLP_ENDPOINT",
+	})
+	otlpInsecure := app.Bool(cli.BoolOpt{
+		Name:   "otlpInsecure",
+		Value:  false,
+		Desc:   "Export traces over plain HTTP instead of HTTPS",
+		EnvVar: "OTLP_INSECURE",
+	})
+	deadLetterTopic := app.String(cli.StringOpt{
+		Name:   "deadLetterTopic",
+		Value:  "",
+		Desc:   "The topic to republish messages which could not be processed to. Dead-lettering is disabled if empty",
+		EnvVar: "DEAD_LETTER_TOPIC",
+	})
+
+	onInvalidConfig := app.String(cli.StringOpt{
+		Name:   "onInvalidConfig",
+		Value:  invalidConfigExit,
+		Desc:   "What to do when any setting is invalid on startup: exit (with a non-zero status) or pause (serve the healthchecks reporting the invalid settings without consuming any messages)",
+		EnvVar: "ON_INVALID_CONFIG",
+	})
 
 	log := logger.NewUPPLogger(appSystemCode, *logLevel)
 
+	app.Command("replay", "Reprocess a range of messages from the consumer topic and write the mapped annotations to a target topic", func(cmd *cli.Cmd) {
+		fromOffset := cmd.Int(cli.IntOpt{
+			Name:  "fromOffset",
+			Value: -1,
+			Desc:  "First offset to replay from every partition. The oldest available offset is used if negative and fromTime is not set",
+		})
+		toOffset := cmd.Int(cli.IntOpt{
+			Name:  "toOffset",
+			Value: -1,
+			Desc:  "Last offset to replay from every partition. The newest offset at start is used if negative and toTime is not set",
+		})
+		fromTime := cmd.String(cli.StringOpt{
+			Name: "fromTime",
+			Desc: "Replay messages published at or after the given RFC3339 time",
+		})
+		toTime := cmd.String(cli.StringOpt{
+			Name: "toTime",
+			Desc: "Replay messages published before the given RFC3339 time",
+		})
+		targetTopic := cmd.String(cli.StringOpt{
+			Name: "targetTopic",
+			Desc: "The topic to write the concept annotations to. The producer topic is used if empty",
+		})
+		dryRun := cmd.Bool(cli.BoolOpt{
+			Name:  "dryRun",
+			Value: false,
+			Desc:  "Only report how many messages would be replayed and written, without writing anything",
+		})
+
+		cmd.Action = func() {
+			mapping, configErrors := validateMappingConfig(settings{
+				whitelistRegex:           *whitelistRegex,
+				filterConfig:             *filterConfig,
+				predicatesConfig:         *predicatesConfig,
+				routingConfig:            *routingConfig,
+				annotationConflictPolicy: *annotationConflictPolicy,
+				unmappableEventPolicy:    *unmappableEventPolicy,
+				deadLetterTopic:          *deadLetterTopic,
+				messageTimeout:           *messageTimeout,
+				producerMaxAttempts:      *producerMaxAttempts,
+				producerRetryBackoff:     *producerRetryBackoff,
+				producerMaxRetryBackoff:  *producerMaxRetryBackoff,
+			}, log)
+			if len(configErrors) > 0 {
+				logConfigErrors(configErrors, log)
+				log.Error("Exiting as the configuration is invalid")
+				cli.Exit(1)
+			}
+
+			config := replayConfig{
+				kafkaAddress:    *kafkaAddress,
+				sourceTopic:     *consumerTopic,
+				targetTopic:     *targetTopic,
+				mapping:         mapping,
+				conflicts:       service.ConflictResolution{Policy: mapping.conflictPolicy, Predicates: *conflictingPredicates},
+				deadLetterTopic: *deadLetterTopic,
+				fromOffset:      int64(*fromOffset),
+				toOffset:        int64(*toOffset),
+				fromTime:        *fromTime,
+				toTime:          *toTime,
+				dryRun:          *dryRun,
+				messageTimeout:  time.Duration(*messageTimeout) * time.Millisecond,
+				passThrough:     *passThroughHeaders,
+				retryPolicy: service.RetryPolicy{
+					MaxAttempts:    *producerMaxAttempts,
+					InitialBackoff: time.Duration(*producerRetryBackoff) * time.Millisecond,
+					MaxBackoff:     time.Duration(*producerMaxRetryBackoff) * time.Millisecond,
+				},
+			}
+			if config.targetTopic == "" {
+				config.targetTopic = *producerTopic
+			}
+
+			if err := runReplay(config, log); err != nil {
+				log.WithError(err).Error("Replay failed")
+				cli.Exit(1)
+			}
+		}
+	})
+
 	app.Action = func() {
 		log.Infof("System code: %s, App Name: %s, Port: %s", appSystemCode, appName, *port)
 
-		whitelist, regexErr := regexp.Compile(*whitelistRegex)
-		if regexErr != nil {
-			log.WithError(regexErr).Error("Please specify a valid whitelist ")
+		config, configErrors := validateConfig(settings{
+			onInvalidConfig:          *onInvalidConfig,
+			whitelistRegex:           *whitelistRegex,
+			filterConfig:             *filterConfig,
+			predicatesConfig:         *predicatesConfig,
+			predicatesReloadInterval: *predicatesReloadInterval,
+			routingConfig:            *routingConfig,
+			annotationConflictPolicy: *annotationConflictPolicy,
+			unmappableEventPolicy:    *unmappableEventPolicy,
+			staleEventPolicy:         *staleEventPolicy,
+			staleEventCacheSize:      *staleEventCacheSize,
+			staleEventStore:          *staleEventStore,
+			deltaMode:                *deltaMode,
+			deltaTopic:               *deltaTopic,
+			deltaCacheSize:           *deltaCacheSize,
+			deadLetterTopic:          *deadLetterTopic,
+			messageTimeout:           *messageTimeout,
+			producerMaxAttempts:      *producerMaxAttempts,
+			producerRetryBackoff:     *producerRetryBackoff,
+			producerMaxRetryBackoff:  *producerMaxRetryBackoff,
+			shutdownTimeout:          *shutdownTimeout,
+			otlpEndpoint:             *otlpEndpoint,
+			otlpInsecure:             *otlpInsecure,
+		}, log)
+		defer config.Close()
+
+		if len(configErrors) > 0 {
+			logConfigErrors(configErrors, log)
+			if *onInvalidConfig != invalidConfigPause {
+				log.Error("Exiting as the configuration is invalid")
+				// cli.Exit does not run the deferred calls
+				config.Close()
+				cli.Exit(1)
+			}
+
+			log.Error("Not consuming any messages until the configuration is fixed and the service is restarted")
+			healthService := health.NewHealthCheck(appSystemCode, appName, appDescription, configErrors, nil, nil)
+			server, serverDone := serveEndpoints(*port, healthService, nil, log)
+
+			waitForSignal()
+			log.Infof("[Shutdown] pac-annotations-mapper is shutting down")
+
+			healthService.SetShuttingDown()
+			shutdownServer(server, log)
+			<-serverDone
+			return
+		}
+
+		if config.tracerProvider != nil {
+			setUpTracing(config.tracerProvider)
 		}
 
 		producerConfig := kafka.ProducerConfig{
 			BrokersConnectionString: *kafkaAddress,
 			Topic:                   *producerTopic,
-			Options:                 kafka.DefaultProducerOptions(),
+			Options:                 producerOptions(time.Duration(*messageTimeout) * time.Millisecond),
 		}
 		messageProducer := kafka.NewProducer(producerConfig, log)
 		defer func() {
@@ -100,7 +385,115 @@ func main() {
 			messageProducer.Close()
 		}()
 
-		mapper := service.NewAnnotationMapperService(whitelist, messageProducer, log)
+		var reloadablePredicates []*service.PredicateMapping
+		if *predicatesConfig != "" {
+			reloadablePredicates = append(reloadablePredicates, config.predicates)
+		}
+
+		mapperOpts := []service.MapperOption{
+			service.WithPredicateMapping(config.predicates),
+			service.WithConflictResolution(service.ConflictResolution{Policy: config.conflictPolicy, Predicates: *conflictingPredicates}),
+			service.WithMessageTimeout(time.Duration(*messageTimeout) * time.Millisecond),
+			service.WithSourceTopic(*consumerTopic),
+			service.WithHeaderPassThrough(*passThroughHeaders),
+			service.WithUnmappablePolicy(config.unmappablePolicy),
+		}
+		if config.originFilter != nil {
+			mapperOpts = append(mapperOpts, service.WithOriginFilter(config.originFilter))
+		}
+		if config.timestamps != nil {
+			mapperOpts = append(mapperOpts, service.WithStaleEventDetection(config.stalePolicy, config.timestamps))
+		}
+		if *auditTopic != "" {
+			auditProducer := kafka.NewProducer(kafka.ProducerConfig{
+				BrokersConnectionString: *kafkaAddress,
+				Topic:                   *auditTopic,
+				Options:                 producerOptions(time.Duration(*messageTimeout) * time.Millisecond),
+			}, log)
+			defer func() {
+				log.Info("Shutting down kafka audit producer")
+				auditProducer.Close()
+			}()
+			mapperOpts = append(mapperOpts, service.WithAuditProducer(auditProducer))
+		}
+		if *deadLetterTopic != "" {
+			deadLetterProducer := kafka.NewProducer(kafka.ProducerConfig{
+				BrokersConnectionString: *kafkaAddress,
+				Topic:                   *deadLetterTopic,
+				Options:                 producerOptions(time.Duration(*messageTimeout) * time.Millisecond),
+			}, log)
+			defer func() {
+				log.Info("Shutting down kafka dead-letter producer")
+				deadLetterProducer.Close()
+			}()
+			mapperOpts = append(mapperOpts, service.WithDeadLetterProducer(deadLetterProducer))
+		}
+
+		retryPolicy := service.RetryPolicy{
+			MaxAttempts:    *producerMaxAttempts,
+			InitialBackoff: time.Duration(*producerRetryBackoff) * time.Millisecond,
+			MaxBackoff:     time.Duration(*producerMaxRetryBackoff) * time.Millisecond,
+		}
+		retryingProducer := service.NewRetryingProducer(messageProducer, retryPolicy, log)
+
+		if config.history != nil {
+			if config.deltaMode == service.DeltaModeField {
+				mapperOpts = append(mapperOpts, service.WithAnnotationDiffing(config.deltaMode, config.history, nil))
+			} else {
+				deltaKafkaProducer := kafka.NewProducer(kafka.ProducerConfig{
+					BrokersConnectionString: *kafkaAddress,
+					Topic:                   *deltaTopic,
+					Options:                 producerOptions(time.Duration(*messageTimeout) * time.Millisecond),
+				}, log)
+				defer func() {
+					log.Info("Shutting down kafka delta producer")
+					deltaKafkaProducer.Close()
+				}()
+				deltaProducer := service.NewRetryingProducer(deltaKafkaProducer, retryPolicy, log)
+				mapperOpts = append(mapperOpts, service.WithAnnotationDiffing(config.deltaMode, config.history, deltaProducer))
+			}
+		}
+
+		if len(config.routes) > 0 {
+			producers := map[string]*service.RetryingProducer{*producerTopic: retryingProducer}
+			var routes []service.Route
+			for _, rc := range config.routes {
+				producer, found := producers[rc.Topic]
+				if !found {
+					routeProducer := kafka.NewProducer(kafka.ProducerConfig{
+						BrokersConnectionString: *kafkaAddress,
+						Topic:                   rc.Topic,
+						Options:                 producerOptions(time.Duration(*messageTimeout) * time.Millisecond),
+					}, log)
+					defer func(topic string) {
+						log.WithField("topic", topic).Info("Shutting down kafka route producer")
+						routeProducer.Close()
+					}(rc.Topic)
+					producer = service.NewRetryingProducer(routeProducer, retryPolicy, log)
+					producers[rc.Topic] = producer
+				}
+
+				routePredicates := config.routePredicates[rc.Name]
+				if routePredicates != nil {
+					reloadablePredicates = append(reloadablePredicates, routePredicates)
+				}
+
+				routes = append(routes, service.Route{
+					Name:           rc.Name,
+					OriginSystemID: regexp.MustCompile(rc.OriginSystemIDPattern),
+					Producer:       producer,
+					Predicates:     routePredicates,
+				})
+			}
+			mapperOpts = append(mapperOpts, service.WithRoutes(routes))
+		}
+
+		for _, p := range reloadablePredicates {
+			go p.Watch(time.Duration(*predicatesReloadInterval)*time.Second, nil)
+		}
+		go reloadOnSignal(reloadablePredicates, log)
+
+		mapper := service.NewAnnotationMapperService(config.whitelist, retryingProducer, log, mapperOpts...)
 
 		kafkaConsumerTopic := []*kafka.Topic{
 			kafka.NewTopic(*consumerTopic, kafka.WithLagTolerance(int64(*kafkaLagTolerance))),
@@ -113,17 +506,20 @@ func main() {
 		}
 		messageConsumer := kafka.NewConsumer(consumerConfig, kafkaConsumerTopic, log)
 
-		go messageConsumer.Start(mapper.HandleMessage)
-		defer func() {
-			log.Info("Shutting down kafka consumer")
-			messageConsumer.Close()
-		}()
+		handlerCtx, cancelHandlers := context.WithCancel(context.Background())
+		go messageConsumer.Start(func(msg kafka.FTMessage) {
+			mapper.HandleMessageWithContext(handlerCtx, msg)
+		})
 
-		healthService := health.NewHealthCheck(appSystemCode, appName, appDescription, regexErr, messageConsumer, messageProducer)
+		healthService := health.NewHealthCheck(appSystemCode, appName, appDescription, nil, messageConsumer, messageProducer)
 
-		go serveEndpoints(*port, healthService, log)
+		server, serverDone := serveEndpoints(*port, healthService, mapper, log)
 
 		waitForSignal()
+		log.Infof("[Shutdown] pac-annotations-mapper is shutting down")
+
+		shutdown(time.Duration(*shutdownTimeout)*time.Second, healthService, messageConsumer, mapper, cancelHandlers, server, log)
+		<-serverDone
 	}
 	err := app.Run(os.Args)
 	if err != nil {
@@ -132,7 +528,7 @@ func main() {
 	}
 }
 
-func serveEndpoints(port string, healthService *health.HealthCheck, log *logger.UPPLogger) {
+func serveEndpoints(port string, healthService *health.HealthCheck, mapper *service.AnnotationMapperService, log *logger.UPPLogger) (*http.Server, <-chan struct{}) {
 	serveMux := http.NewServeMux()
 
 	hc := fthealth.TimedHealthCheck{
@@ -148,27 +544,127 @@ func serveEndpoints(port string, healthService *health.HealthCheck, log *logger.
 	serveMux.HandleFunc(health.HealthPath, fthealth.Handler(hc))
 	serveMux.HandleFunc(status.GTGPath, status.NewGoodToGoHandler(healthService.GTG))
 	serveMux.HandleFunc(status.BuildInfoPath, status.BuildInfoHandler)
+	if mapper != nil {
+		serveMux.HandleFunc(service.MapPath, mapper.MapHandler)
+	}
+	serveMux.Handle(metricsPath, promhttp.Handler())
 
 	server := &http.Server{Addr: ":" + port, Handler: serveMux}
 
-	wg := sync.WaitGroup{}
-
-	wg.Add(1)
+	done := make(chan struct{})
 	go func() {
 		if err := server.ListenAndServe(); err != nil {
 			log.Infof("HTTP server closing with message: %v", err)
 		}
-		wg.Done()
+		close(done)
+	}()
+
+	return server, done
+}
+
+// shutdown stops the service without losing the messages being handled. GTG is flipped to unhealthy first,
+// so Kubernetes stops routing traffic, then message consumption is stopped and the consumer group session is released,
+// which waits for the handler and commits the offsets of the handled messages. Once all in-progress messages have
+// been handled the HTTP server is shut down. Messages still being handled when the timeout elapses are cancelled
+// and dead-lettered, which is given a short grace period on top of the timeout, as is shutting down the HTTP server.
+// The producers are closed by the caller afterwards. The other steps are given whatever is left of the timeout.
+func shutdown(timeout time.Duration, healthService *health.HealthCheck, consumer io.Closer, mapper *service.AnnotationMapperService, cancelHandlers context.CancelFunc, server *http.Server, log *logger.UPPLogger) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	healthService.SetShuttingDown()
+
+	log.Info("Shutting down kafka consumer")
+	consumerClosed := make(chan error, 1)
+	go func() {
+		consumerClosed <- consumer.Close()
 	}()
+	select {
+	case err := <-consumerClosed:
+		if err != nil {
+			log.WithError(err).Error("Error closing kafka consumer")
+		}
+	case <-ctx.Done():
+		log.Error("Timed out waiting for kafka consumer to close, offsets of the last handled messages may not be committed")
+	}
 
-	waitForSignal()
-	log.Infof("[Shutdown] pac-annotations-mapper is shutting down")
+	if err := mapper.Drain(ctx); err != nil {
+		log.WithError(err).Error("Timed out waiting for in-progress messages to be handled, cancelling them")
+		cancelHandlers()
 
-	if err := server.Close(); err != nil {
+		// The cancelled messages are dead-lettered, which has to happen before the producers are closed.
+		graceCtx, cancelGrace := context.WithTimeout(context.Background(), cancelledMessagesGracePeriod)
+		defer cancelGrace()
+		if err := mapper.Drain(graceCtx); err != nil {
+			log.WithError(err).Error("Timed out waiting for cancelled messages to be dead-lettered")
+		}
+	}
+
+	shutdownServer(server, log)
+}
+
+// shutdownServer waits for the in-flight HTTP requests to complete, regardless of how long the shutdown took so far.
+func shutdownServer(server *http.Server, log *logger.UPPLogger) {
+	ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
 		log.Errorf("Unable to stop http server: %v", err)
 	}
+}
+
+// producerOptions bounds a single send of the Kafka producer by the message timeout, as the producer cannot be
+// cancelled. The producer makes fewer attempts, as the sends are retried by the service, and each attempt gets
+// an equal share of the timeout for connecting, writing the message and reading the acknowledgement of the brokers.
+func producerOptions(messageTimeout time.Duration) *sarama.Config {
+	config := kafka.DefaultProducerOptions()
+	if messageTimeout <= 0 {
+		return config
+	}
+
+	config.Producer.Retry.Max = producerAttemptsPerSend - 1
+	// connecting, writing and reading share the time of an attempt
+	timeout := (messageTimeout/producerAttemptsPerSend - config.Producer.Retry.Backoff) / 3
+	if timeout < minProducerNetworkTimeout {
+		timeout = minProducerNetworkTimeout
+	}
+	config.Net.DialTimeout = timeout
+	config.Net.WriteTimeout = timeout
+	config.Net.ReadTimeout = timeout
+	// the brokers wait for the acknowledgements while the producer reads the response
+	config.Producer.Timeout = timeout
+	return config
+}
 
-	wg.Wait()
+func logConfigErrors(configErrors []health.ConfigError, log *logger.UPPLogger) {
+	for _, ce := range configErrors {
+		log.WithError(ce.Err).WithField("setting", ce.Setting).Error("Invalid configuration setting")
+	}
+}
+
+func loadOriginFilter(path string) (*service.OriginFilter, error) {
+	config, err := service.LoadFilterConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return service.NewOriginFilter(config)
+}
+
+func reloadOnSignal(predicates []*service.PredicateMapping, log *logger.UPPLogger) {
+	if len(predicates) == 0 {
+		return
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		for _, p := range predicates {
+			if err := p.Reload(); err != nil {
+				log.WithError(err).WithField("path", p.Path()).Error("Keeping the current predicate mapping as the file is invalid")
+				continue
+			}
+			log.WithField("path", p.Path()).Info("Reloaded predicate mapping on SIGHUP")
+		}
+	}
 }
 
 func waitForSignal() {
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/v3"
+	"github.com/Financial-Times/pac-annotations-mapper/replay"
+	"github.com/Financial-Times/pac-annotations-mapper/service"
+)
+
+const producerConnectionTimeout = time.Minute
+
+type replayConfig struct {
+	kafkaAddress    string
+	sourceTopic     string
+	targetTopic     string
+	mapping         *validConfig
+	conflicts       service.ConflictResolution
+	deadLetterTopic string
+	fromOffset      int64
+	toOffset        int64
+	fromTime        string
+	toTime          string
+	dryRun          bool
+	messageTimeout  time.Duration
+	passThrough     []string
+	retryPolicy     service.RetryPolicy
+}
+
+func runReplay(config replayConfig, log *logger.UPPLogger) error {
+	replayRange := replay.Range{FromOffset: config.fromOffset, ToOffset: config.toOffset}
+	var err error
+	if config.fromTime != "" {
+		if replayRange.FromTime, err = time.Parse(time.RFC3339, config.fromTime); err != nil {
+			return fmt.Errorf("invalid fromTime: %w", err)
+		}
+	}
+	if config.toTime != "" {
+		if replayRange.ToTime, err = time.Parse(time.RFC3339, config.toTime); err != nil {
+			return fmt.Errorf("invalid toTime: %w", err)
+		}
+	}
+
+	mapperOpts := []service.MapperOption{
+		service.WithConflictResolution(config.conflicts),
+		service.WithMessageTimeout(config.messageTimeout),
+		service.WithHeaderPassThrough(config.passThrough),
+		service.WithUnmappablePolicy(config.mapping.unmappablePolicy),
+	}
+	if config.mapping.originFilter != nil {
+		mapperOpts = append(mapperOpts, service.WithOriginFilter(config.mapping.originFilter))
+	}
+
+	var closers []io.Closer
+	newProducer := func(topic string) (*replay.CountingProducer, error) {
+		if config.dryRun {
+			return replay.NewCountingProducer(nil), nil
+		}
+
+		messageProducer := kafka.NewProducer(kafka.ProducerConfig{
+			BrokersConnectionString: config.kafkaAddress,
+			Topic:                   topic,
+			Options:                 producerOptions(config.messageTimeout),
+		}, log)
+		if err := waitForProducer(messageProducer, producerConnectionTimeout); err != nil {
+			messageProducer.Close()
+			return nil, err
+		}
+		closers = append(closers, messageProducer)
+		return replay.NewCountingProducer(service.NewRetryingProducer(messageProducer, config.retryPolicy, log)), nil
+	}
+	// producers are shared by the routes writing to the same topic, and counted together.
+	producers := map[string]*replay.CountingProducer{}
+	producerFor := func(topic string) (*replay.CountingProducer, error) {
+		if producer, found := producers[topic]; found {
+			return producer, nil
+		}
+		producer, err := newProducer(topic)
+		if err != nil {
+			return nil, err
+		}
+		producers[topic] = producer
+		return producer, nil
+	}
+	defer func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}()
+
+	producer, err := producerFor(config.targetTopic)
+	if err != nil {
+		return err
+	}
+
+	var deadLetterProducer *replay.CountingProducer
+	if config.deadLetterTopic != "" {
+		// dead-lettered messages are counted apart from the written ones
+		if deadLetterProducer, err = newProducer(config.deadLetterTopic); err != nil {
+			return err
+		}
+		mapperOpts = append(mapperOpts, service.WithDeadLetterProducer(deadLetterProducer))
+	}
+
+	if len(config.mapping.routes) > 0 {
+		var routes []service.Route
+		for _, rc := range config.mapping.routes {
+			routeProducer, err := producerFor(rc.Topic)
+			if err != nil {
+				return err
+			}
+			routes = append(routes, service.Route{
+				Name:           rc.Name,
+				OriginSystemID: regexp.MustCompile(rc.OriginSystemIDPattern),
+				Producer:       routeProducer,
+				Predicates:     config.mapping.routePredicates[rc.Name],
+			})
+		}
+		mapperOpts = append(mapperOpts, service.WithRoutes(routes))
+	}
+
+	mapperOpts = append(mapperOpts, service.WithPredicateMapping(config.mapping.predicates))
+	mapper := service.NewAnnotationMapperService(config.mapping.whitelist, producer, log, mapperOpts...)
+
+	log.WithField("sourceTopic", config.sourceTopic).
+		WithField("targetTopic", config.targetTopic).
+		WithField("dryRun", config.dryRun).
+		Info("Starting replay")
+
+	stats, err := replay.Run(replay.Config{
+		BrokersConnectionString: config.kafkaAddress,
+		Topic:                   config.sourceTopic,
+		Range:                   replayRange,
+	}, func(msg kafka.FTMessage, partition int32, offset int64) {
+		source := service.MessageSource{Topic: config.sourceTopic, Partition: partition, Offset: offset}
+		mapper.HandleMessageWithContext(service.ContextWithMessageSource(context.Background(), source), msg)
+	}, log)
+
+	for _, p := range stats.Partitions {
+		log.WithField("partition", p.Partition).
+			WithField("from", p.From).
+			WithField("to", p.To).
+			WithField("read", p.Read).
+			Info("Replayed partition")
+	}
+	var sent, failed int
+	for topic, p := range producers {
+		topicSent, topicFailed := p.Counts()
+		log.WithField("topic", topic).
+			WithField("written", topicSent).
+			WithField("failed", topicFailed).
+			Info("Replayed to topic")
+		sent += topicSent
+		failed += topicFailed
+	}
+	finished := log.WithField("read", stats.Read).
+		WithField("written", sent).
+		WithField("failed", failed).
+		WithField("dryRun", config.dryRun)
+	if deadLetterProducer != nil {
+		deadLettered, _ := deadLetterProducer.Counts()
+		finished = finished.WithField("deadLettered", deadLettered)
+	}
+	finished.Info("Replay finished")
+
+	return err
+}
+
+// waitForProducer waits until the producer has connected, as it connects to Kafka in the background.
+func waitForProducer(producer *kafka.Producer, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := producer.ConnectivityCheck()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("producer could not connect to Kafka: %w", err)
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,41 @@
+package replay
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Financial-Times/kafka-client-go/v3"
+)
+
+// The character sets of the headers decoded by the kafka client consumer. Header values are cut at the first
+// character outside of them, which has to be done the same way so replayed messages carry the same headers.
+var (
+	headerLineRegex  = regexp.MustCompile("[\\w-]*:[\\w\\-:/.+;= ]*")
+	headerKeyRegex   = regexp.MustCompile("[\\w-]*:")
+	headerValueRegex = regexp.MustCompile(":[\\w-:/.+;= ]*")
+)
+
+// parseFTMessage decodes a raw FTMSG/1.0 message the same way the kafka client consumer decodes it for the live service.
+func parseFTMessage(raw []byte) kafka.FTMessage {
+	msg := string(raw)
+
+	headerEnd := strings.Index(msg, "\r\n\r\n")
+	if headerEnd == -1 {
+		headerEnd = strings.Index(msg, "\n\n")
+	}
+	if headerEnd == -1 {
+		headerEnd = len(msg)
+	}
+
+	headers := make(map[string]string)
+	for _, line := range headerLineRegex.FindAllString(msg[:headerEnd], -1) {
+		key := headerKeyRegex.FindString(line)
+		value := headerValueRegex.FindString(line)
+		headers[key[:len(key)-1]] = strings.TrimSpace(value[1:])
+	}
+
+	return kafka.FTMessage{
+		Headers: headers,
+		Body:    strings.TrimSpace(msg[headerEnd:]),
+	}
+}
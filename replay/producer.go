@@ -0,0 +1,53 @@
+package replay
+
+import (
+	"sync"
+
+	"github.com/Financial-Times/kafka-client-go/v3"
+)
+
+type messageProducer interface {
+	SendMessage(message kafka.FTMessage) error
+}
+
+// CountingProducer counts the messages sent through it.
+// Without a delegate producer nothing is sent, which is used for dry runs.
+type CountingProducer struct {
+	producer messageProducer
+	lock     *sync.Mutex
+	sent     int
+	failed   int
+}
+
+func NewCountingProducer(producer messageProducer) *CountingProducer {
+	return &CountingProducer{
+		producer: producer,
+		lock:     &sync.Mutex{},
+	}
+}
+
+func (p *CountingProducer) SendMessage(message kafka.FTMessage) error {
+	var err error
+	if p.producer != nil {
+		err = p.producer.SendMessage(message)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err != nil {
+		p.failed++
+	} else {
+		p.sent++
+	}
+
+	return err
+}
+
+// Counts returns how many messages have been sent successfully and how many have failed.
+func (p *CountingProducer) Counts() (int, int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.sent, p.failed
+}
@@ -0,0 +1,194 @@
+package replay
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/v3"
+	"github.com/Shopify/sarama"
+)
+
+const defaultIdleTimeout = 30 * time.Second
+
+// Range selects the messages to replay from every partition of the topic.
+// Offsets take precedence over times. Unset bounds default to the oldest and the newest available offsets,
+// the newest one being taken when the replay starts.
+type Range struct {
+	// FromOffset is the first offset to replay, -1 if unset.
+	FromOffset int64
+	// ToOffset is the last offset to replay, -1 if unset.
+	ToOffset int64
+	// FromTime replays messages published at or after the given time.
+	FromTime time.Time
+	// ToTime replays messages published before the given time.
+	ToTime time.Time
+}
+
+type Config struct {
+	BrokersConnectionString string
+	Topic                   string
+	Range                   Range
+	// Time to wait for the next message of a partition before giving up.
+	// Default value (30 seconds) would be used if not set.
+	IdleTimeout time.Duration
+}
+
+// PartitionStats describes the messages replayed from a single partition, starting at offset From up to To (exclusive).
+type PartitionStats struct {
+	Partition int32
+	From      int64
+	To        int64
+	Read      int
+}
+
+type Stats struct {
+	Partitions []PartitionStats
+	Read       int
+}
+
+type offsetFetcher interface {
+	GetOffset(topic string, partition int32, time int64) (int64, error)
+}
+
+// Handler handles a replayed message, along with the partition and offset it was read from.
+type Handler func(message kafka.FTMessage, partition int32, offset int64)
+
+// Run reads the selected range of messages from every partition of the topic and passes them to the handler.
+// Partitions are read one after another with plain partition consumers, so no consumer group offsets are committed
+// and the live consumer group is left untouched.
+func Run(config Config, handler Handler, log *logger.UPPLogger) (Stats, error) {
+	brokers := strings.Split(config.BrokersConnectionString, ",")
+	client, err := sarama.NewClient(brokers, sarama.NewConfig())
+	if err != nil {
+		return Stats{}, fmt.Errorf("cannot connect to Kafka: %w", err)
+	}
+	defer client.Close()
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return Stats{}, fmt.Errorf("cannot create Kafka consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	partitions, err := client.Partitions(config.Topic)
+	if err != nil {
+		return Stats{}, fmt.Errorf("cannot list partitions of topic %s: %w", config.Topic, err)
+	}
+
+	idleTimeout := config.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+
+	var stats Stats
+	for _, partition := range partitions {
+		from, to, err := resolveBounds(client, config.Topic, partition, config.Range)
+		if err != nil {
+			return stats, err
+		}
+
+		partitionStats := PartitionStats{Partition: partition, From: from, To: to}
+		partitionLog := log.WithField("topic", config.Topic).
+			WithField("partition", partition).
+			WithField("from", from).
+			WithField("to", to)
+
+		if from < to {
+			partitionLog.Info("Replaying partition")
+			partitionStats.Read, err = consumePartition(consumer, config.Topic, partition, from, to, idleTimeout, handler)
+		}
+
+		stats.Partitions = append(stats.Partitions, partitionStats)
+		stats.Read += partitionStats.Read
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, nil
+}
+
+// resolveBounds returns the first offset to replay and the offset to stop before for the given partition.
+func resolveBounds(fetcher offsetFetcher, topic string, partition int32, r Range) (int64, int64, error) {
+	oldest, err := fetcher.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot get oldest offset of partition %d: %w", partition, err)
+	}
+	newest, err := fetcher.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot get newest offset of partition %d: %w", partition, err)
+	}
+
+	from := oldest
+	switch {
+	case r.FromOffset >= 0:
+		from = r.FromOffset
+	case !r.FromTime.IsZero():
+		from, err = offsetForTime(fetcher, topic, partition, r.FromTime, newest)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if from < oldest {
+		from = oldest
+	}
+
+	to := newest
+	switch {
+	case r.ToOffset >= 0:
+		to = r.ToOffset + 1
+	case !r.ToTime.IsZero():
+		to, err = offsetForTime(fetcher, topic, partition, r.ToTime, newest)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if to > newest {
+		to = newest
+	}
+
+	return from, to, nil
+}
+
+// offsetForTime returns the offset of the first message published at or after the given time,
+// or the newest offset if there is no such message.
+func offsetForTime(fetcher offsetFetcher, topic string, partition int32, t time.Time, newest int64) (int64, error) {
+	offset, err := fetcher.GetOffset(topic, partition, t.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return 0, fmt.Errorf("cannot get offset of partition %d for time %s: %w", partition, t, err)
+	}
+	if offset < 0 {
+		return newest, nil
+	}
+	return offset, nil
+}
+
+func consumePartition(consumer sarama.Consumer, topic string, partition int32, from int64, to int64, idleTimeout time.Duration, handler Handler) (int, error) {
+	pc, err := consumer.ConsumePartition(topic, partition, from)
+	if err != nil {
+		return 0, fmt.Errorf("cannot consume partition %d: %w", partition, err)
+	}
+	defer pc.Close()
+
+	read := 0
+	for {
+		select {
+		case msg := <-pc.Messages():
+			if msg.Offset >= to {
+				return read, nil
+			}
+
+			handler(parseFTMessage(msg.Value), msg.Partition, msg.Offset)
+			read++
+			if msg.Offset >= to-1 {
+				return read, nil
+			}
+		case err := <-pc.Errors():
+			return read, fmt.Errorf("error consuming partition %d: %w", partition, err)
+		case <-time.After(idleTimeout):
+			return read, fmt.Errorf("no message received from partition %d for %s", partition, idleTimeout)
+		}
+	}
+}
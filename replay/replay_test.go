@@ -0,0 +1,140 @@
+package replay
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/kafka-client-go/v3"
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTopic = "NativeCmsMetadataPublicationEvents"
+
+type mockOffsetFetcher struct {
+	oldest  int64
+	newest  int64
+	forTime map[int64]int64
+}
+
+func (f mockOffsetFetcher) GetOffset(_ string, _ int32, t int64) (int64, error) {
+	switch t {
+	case sarama.OffsetOldest:
+		return f.oldest, nil
+	case sarama.OffsetNewest:
+		return f.newest, nil
+	}
+	offset, found := f.forTime[t]
+	if !found {
+		return 0, fmt.Errorf("unexpected time %d", t)
+	}
+	return offset, nil
+}
+
+func TestResolveBounds(t *testing.T) {
+	from := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 7, 2, 0, 0, 0, 0, time.UTC)
+	fetcher := mockOffsetFetcher{
+		oldest: 10,
+		newest: 100,
+		forTime: map[int64]int64{
+			from.UnixMilli(): 20,
+			to.UnixMilli():   -1,
+		},
+	}
+
+	tests := map[string]struct {
+		r            Range
+		expectedFrom int64
+		expectedTo   int64
+	}{
+		"whole partition": {
+			r:            Range{FromOffset: -1, ToOffset: -1},
+			expectedFrom: 10,
+			expectedTo:   100,
+		},
+		"offsets": {
+			r:            Range{FromOffset: 15, ToOffset: 50},
+			expectedFrom: 15,
+			expectedTo:   51,
+		},
+		"offsets outside of the partition": {
+			r:            Range{FromOffset: 0, ToOffset: 500},
+			expectedFrom: 10,
+			expectedTo:   100,
+		},
+		"times": {
+			r:            Range{FromOffset: -1, ToOffset: -1, FromTime: from, ToTime: to},
+			expectedFrom: 20,
+			expectedTo:   100,
+		},
+		"offsets take precedence over times": {
+			r:            Range{FromOffset: 30, ToOffset: 40, FromTime: from, ToTime: to},
+			expectedFrom: 30,
+			expectedTo:   41,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			actualFrom, actualTo, err := resolveBounds(fetcher, testTopic, 0, test.r)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedFrom, actualFrom, "from offset")
+			assert.Equal(t, test.expectedTo, actualTo, "to offset")
+		})
+	}
+}
+
+func TestConsumePartitionStopsAtEndOffset(t *testing.T) {
+	consumer := mocks.NewConsumer(t, nil)
+	pc := consumer.ExpectConsumePartition(testTopic, 0, 5)
+	for i := 0; i < 4; i++ {
+		pc.YieldMessage(&sarama.ConsumerMessage{Value: []byte(fmt.Sprintf("FTMSG/1.0\r\nX-Request-Id: tid_%d\r\n\r\n{}", i))})
+	}
+
+	var handled []kafka.FTMessage
+	var offsets []int64
+	read, err := consumePartition(consumer, testTopic, 0, 5, 8, time.Second, func(message kafka.FTMessage, partition int32, offset int64) {
+		handled = append(handled, message)
+		offsets = append(offsets, offset)
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, read)
+	require.Len(t, handled, 3)
+	assert.Equal(t, "tid_2", handled[2].Headers["X-Request-Id"])
+	assert.Equal(t, []int64{5, 6, 7}, offsets)
+}
+
+func TestConsumePartitionReturnsErrorWhenIdle(t *testing.T) {
+	consumer := mocks.NewConsumer(t, nil)
+	consumer.ExpectConsumePartition(testTopic, 0, 0)
+
+	read, err := consumePartition(consumer, testTopic, 0, 0, 10, 10*time.Millisecond, func(kafka.FTMessage, int32, int64) {})
+	assert.Error(t, err)
+	assert.Zero(t, read)
+}
+
+func TestParseFTMessage(t *testing.T) {
+	raw := "FTMSG/1.0\r\nMessage-Id: 4a8c0d98-f1f4-4ec4-9e0c-3b1aa4c7e2a6\r\nOrigin-System-Id: http://cmdb.ft.com/systems/pac\r\n\r\n{\"uuid\":\"foo\"}\n"
+
+	msg := parseFTMessage([]byte(raw))
+	assert.Equal(t, map[string]string{
+		"Message-Id":       "4a8c0d98-f1f4-4ec4-9e0c-3b1aa4c7e2a6",
+		"Origin-System-Id": "http://cmdb.ft.com/systems/pac",
+	}, msg.Headers)
+	assert.Equal(t, `{"uuid":"foo"}`, msg.Body)
+}
+
+func TestParseFTMessageCutsHeaderValuesLikeTheConsumer(t *testing.T) {
+	raw := "FTMSG/1.0\r\nX-Request-Id: tid_abc,def\r\nOrigin-System-Id: http://cmdb.ft.com/systems/pac?x\r\n\r\n{}"
+
+	msg := parseFTMessage([]byte(raw))
+	assert.Equal(t, map[string]string{
+		"X-Request-Id":     "tid_abc",
+		"Origin-System-Id": "http://cmdb.ft.com/systems/pac",
+	}, msg.Headers)
+}
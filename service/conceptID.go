@@ -0,0 +1,40 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const thingURIPrefix = "http://www.ft.com/thing/"
+
+var uuidRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// NormalisedConceptID records a concept ID which was rewritten to its canonical form.
+type NormalisedConceptID struct {
+	Original   string `json:"original"`
+	Normalised string `json:"normalised"`
+}
+
+// normaliseConceptID returns the canonical UPP thing URI, http://www.ft.com/thing/{uuid}, for a concept ID.
+// Bare UUIDs and thing URIs using https, upper case UUIDs or a trailing slash are accepted.
+// Any other ID is rejected.
+func normaliseConceptID(id string) (string, error) {
+	if uuidRegex.MatchString(id) {
+		return thingURIPrefix + strings.ToLower(id), nil
+	}
+
+	u, err := url.Parse(id)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || !strings.EqualFold(u.Host, "www.ft.com") ||
+		u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("concept id %q is neither a UUID nor a thing URI", id)
+	}
+
+	conceptUUID := strings.TrimSuffix(strings.TrimPrefix(u.Path, "/thing/"), "/")
+	if !strings.HasPrefix(u.Path, "/thing/") || !uuidRegex.MatchString(conceptUUID) {
+		return "", fmt.Errorf("concept id %q is not a thing URI with a UUID", id)
+	}
+
+	return thingURIPrefix + strings.ToLower(conceptUUID), nil
+}
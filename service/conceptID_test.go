@@ -0,0 +1,75 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormaliseConceptID(t *testing.T) {
+	const canonical = "http://www.ft.com/thing/1fb3faf1-bf00-3a15-8efb-1038a19b1d33"
+
+	tests := map[string]struct {
+		id          string
+		expected    string
+		expectError bool
+	}{
+		"canonical": {
+			id:       canonical,
+			expected: canonical,
+		},
+		"bare uuid": {
+			id:       "1fb3faf1-bf00-3a15-8efb-1038a19b1d33",
+			expected: canonical,
+		},
+		"upper case uuid": {
+			id:       "1FB3FAF1-BF00-3A15-8EFB-1038A19B1D33",
+			expected: canonical,
+		},
+		"https": {
+			id:       "https://www.ft.com/thing/1fb3faf1-bf00-3a15-8efb-1038a19b1d33",
+			expected: canonical,
+		},
+		"trailing slash": {
+			id:       "http://www.ft.com/thing/1fb3faf1-bf00-3a15-8efb-1038a19b1d33/",
+			expected: canonical,
+		},
+		"not a uuid": {
+			id:          "bar",
+			expectError: true,
+		},
+		"thing URI without a uuid": {
+			id:          "http://www.ft.com/thing/bar",
+			expectError: true,
+		},
+		"other host": {
+			id:          "http://www.example.com/thing/1fb3faf1-bf00-3a15-8efb-1038a19b1d33",
+			expectError: true,
+		},
+		"other path": {
+			id:          "http://www.ft.com/ontology/1fb3faf1-bf00-3a15-8efb-1038a19b1d33",
+			expectError: true,
+		},
+		"nested path": {
+			id:          "http://www.ft.com/thing/foo/1fb3faf1-bf00-3a15-8efb-1038a19b1d33",
+			expectError: true,
+		},
+		"empty": {
+			id:          "",
+			expectError: true,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			actual, err := normaliseConceptID(test.id)
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package service
+
+import "fmt"
+
+const (
+	dropReasonDuplicate            = "duplicate annotation"
+	dropReasonConflictingPredicate = "conflicting predicate"
+)
+
+// ConflictPolicy decides what happens when a concept is annotated with more than one of the conflicting predicates.
+type ConflictPolicy string
+
+const (
+	// ConflictPolicyKeepAll keeps every annotation of the concept.
+	ConflictPolicyKeepAll ConflictPolicy = "keepAll"
+	// ConflictPolicyPrecedence keeps only the annotation whose predicate comes first in the conflicting predicates.
+	ConflictPolicyPrecedence ConflictPolicy = "precedence"
+	// ConflictPolicyDropAll drops every conflicting annotation of the concept.
+	ConflictPolicyDropAll ConflictPolicy = "dropAll"
+)
+
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(s); p {
+	case ConflictPolicyKeepAll, ConflictPolicyPrecedence, ConflictPolicyDropAll:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy %q, expected one of %s, %s or %s", s, ConflictPolicyKeepAll, ConflictPolicyPrecedence, ConflictPolicyDropAll)
+}
+
+// ConflictResolution configures how annotations of the same concept with conflicting UPP predicates,
+// e.g. about and mentions, are resolved. Predicates are listed in order of precedence.
+type ConflictResolution struct {
+	Policy     ConflictPolicy
+	Predicates []string
+}
+
+// mappedCandidate is a mapped annotation along with the PAC annotation it was mapped from.
+type mappedCandidate struct {
+	source PacMetadataAnnotation
+	mapped annotation
+}
+
+// resolve removes repeated concept and predicate pairs, keeping the first one,
+// then resolves conflicting predicates of the same concept according to the policy.
+func (r ConflictResolution) resolve(candidates []mappedCandidate) ([]mappedCandidate, []DroppedAnnotation) {
+	var kept []mappedCandidate
+	var dropped []DroppedAnnotation
+
+	seen := make(map[concept]bool, len(candidates))
+	for _, c := range candidates {
+		if seen[c.mapped.Concept] {
+			dropped = append(dropped, DroppedAnnotation{Annotation: c.source, Reason: dropReasonDuplicate})
+			continue
+		}
+		seen[c.mapped.Concept] = true
+		kept = append(kept, c)
+	}
+
+	if r.Policy == "" || r.Policy == ConflictPolicyKeepAll {
+		return kept, dropped
+	}
+
+	rank := make(map[string]int, len(r.Predicates))
+	for i, p := range r.Predicates {
+		rank[p] = i
+	}
+
+	// the best ranked conflicting predicate and the number of conflicting predicates of each concept
+	best := map[string]int{}
+	count := map[string]int{}
+	for _, c := range kept {
+		i, conflicting := rank[c.mapped.Concept.Predicate]
+		if !conflicting {
+			continue
+		}
+		if b, found := best[c.mapped.Concept.ID]; !found || i < b {
+			best[c.mapped.Concept.ID] = i
+		}
+		count[c.mapped.Concept.ID]++
+	}
+
+	resolved := kept[:0]
+	for _, c := range kept {
+		i, conflicting := rank[c.mapped.Concept.Predicate]
+		id := c.mapped.Concept.ID
+		if conflicting && count[id] > 1 && (r.Policy == ConflictPolicyDropAll || i != best[id]) {
+			dropped = append(dropped, DroppedAnnotation{Annotation: c.source, Reason: dropReasonConflictingPredicate})
+			continue
+		}
+		resolved = append(resolved, c)
+	}
+
+	return resolved, dropped
+}
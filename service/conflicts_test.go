@@ -0,0 +1,90 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConflictResolution(t *testing.T) {
+	const (
+		conceptA = thingURIPrefix + "1fb3faf1-bf00-3a15-8efb-1038a19b1d33"
+		conceptB = thingURIPrefix + "3cd1a4e1-4f83-3b7c-9e4c-2d7c4cfd4f6e"
+	)
+	candidate := func(id string, predicate string) mappedCandidate {
+		return mappedCandidate{
+			source: PacMetadataAnnotation{Predicate: "http://www.ft.com/ontology/annotation/" + predicate, ConceptId: id},
+			mapped: annotation{Concept: concept{ID: id, Predicate: predicate}},
+		}
+	}
+	candidates := []mappedCandidate{
+		candidate(conceptA, "mentions"),
+		candidate(conceptA, "mentions"),
+		candidate(conceptA, "about"),
+		candidate(conceptA, "hasAuthor"),
+		candidate(conceptB, "mentions"),
+	}
+
+	tests := map[string]struct {
+		policy          ConflictPolicy
+		expectedKept    []mappedCandidate
+		expectedDropped []DroppedAnnotation
+	}{
+		"keep all": {
+			policy: ConflictPolicyKeepAll,
+			expectedKept: []mappedCandidate{
+				candidate(conceptA, "mentions"),
+				candidate(conceptA, "about"),
+				candidate(conceptA, "hasAuthor"),
+				candidate(conceptB, "mentions"),
+			},
+			expectedDropped: []DroppedAnnotation{
+				{Annotation: candidate(conceptA, "mentions").source, Reason: dropReasonDuplicate},
+			},
+		},
+		"precedence": {
+			policy: ConflictPolicyPrecedence,
+			expectedKept: []mappedCandidate{
+				candidate(conceptA, "about"),
+				candidate(conceptA, "hasAuthor"),
+				candidate(conceptB, "mentions"),
+			},
+			expectedDropped: []DroppedAnnotation{
+				{Annotation: candidate(conceptA, "mentions").source, Reason: dropReasonDuplicate},
+				{Annotation: candidate(conceptA, "mentions").source, Reason: dropReasonConflictingPredicate},
+			},
+		},
+		"drop all": {
+			policy: ConflictPolicyDropAll,
+			expectedKept: []mappedCandidate{
+				candidate(conceptA, "hasAuthor"),
+				candidate(conceptB, "mentions"),
+			},
+			expectedDropped: []DroppedAnnotation{
+				{Annotation: candidate(conceptA, "mentions").source, Reason: dropReasonDuplicate},
+				{Annotation: candidate(conceptA, "mentions").source, Reason: dropReasonConflictingPredicate},
+				{Annotation: candidate(conceptA, "about").source, Reason: dropReasonConflictingPredicate},
+			},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			r := ConflictResolution{Policy: test.policy, Predicates: []string{"about", "mentions"}}
+			input := append([]mappedCandidate{}, candidates...)
+
+			kept, dropped := r.resolve(input)
+			assert.Equal(t, test.expectedKept, kept)
+			assert.Equal(t, test.expectedDropped, dropped)
+		})
+	}
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	policy, err := ParseConflictPolicy("precedence")
+	assert.NoError(t, err)
+	assert.Equal(t, ConflictPolicyPrecedence, policy)
+
+	_, err = ParseConflictPolicy("keepSome")
+	assert.Error(t, err)
+}
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"context"
+
+	"github.com/Financial-Times/kafka-client-go/v3"
+)
+
+// contextProducer is implemented by producers which stop sending a message once the context is done.
+type contextProducer interface {
+	SendMessageWithContext(ctx context.Context, message kafka.FTMessage) error
+}
+
+// sendMessage sends the message with the producer, returning the context error if the context is done first.
+// Producers which are not context aware cannot be interrupted, so the context only stops a send which has not started yet:
+// once started the send is waited for and its own outcome is returned, so that a message is never reported as failed
+// while it may still be written.
+func sendMessage(ctx context.Context, producer kafkaProducer, message kafka.FTMessage) error {
+	if p, ok := producer.(contextProducer); ok {
+		return p.SendMessageWithContext(ctx, message)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return producer.SendMessage(message)
+}
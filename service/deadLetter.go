@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"github.com/Financial-Times/kafka-client-go/v3"
+)
+
+const (
+	deadLetterStageUnmarshal  = "unmarshal"
+	deadLetterStageValidation = "validation"
+	deadLetterStageProduce    = "produce"
+	deadLetterStageTimeout    = "timeout"
+	deadLetterStageCancelled  = "cancelled"
+	deadLetterStageUnmappable = "unmappable"
+)
+
+// unsafeHeaderChars matches the characters that would be lost when the FT message headers are parsed back by a consumer.
+var unsafeHeaderChars = regexp.MustCompile(`[^\w\-:/.+;= ]`)
+
+// sendToDeadLetter republishes the original message to the dead-letter topic, together with headers describing
+// at which stage and why it could not be processed. It is a no-op if no dead-letter producer has been configured.
+func (mapper *AnnotationMapperService) sendToDeadLetter(ctx context.Context, msg kafka.FTMessage, tid string, stage string, cause error) {
+	if mapper.deadLetterProducer == nil {
+		return
+	}
+
+	headers := make(map[string]string, len(msg.Headers)+6)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers["Dead-Letter-Stage"] = stage
+	headers["Dead-Letter-Error"] = unsafeHeaderChars.ReplaceAllString(cause.Error(), "_")
+	headers["Dead-Letter-Timestamp"] = time.Now().Format(messageTimestampDateFormat)
+	mapper.addSourceHeaders(ctx, headers, "Dead-Letter-Source-")
+
+	err := mapper.deadLetterProducer.SendMessage(kafka.FTMessage{Headers: headers, Body: msg.Body})
+	if err != nil {
+		mapper.log.WithMonitoringEvent(mapperEvent, tid, annotationsType).
+			WithValidFlag(false).
+			WithError(err).
+			WithField("stage", stage).
+			Error("Error sending message to the dead-letter queue")
+		return
+	}
+
+	mapper.log.WithTransactionID(tid).
+		WithField("stage", stage).
+		Warn("Sent message to the dead-letter queue")
+}
@@ -0,0 +1,18 @@
+package service
+
+import "strings"
+
+const (
+	// deleteEventHeader marks a PAC metadata publish event as a delete when set to true,
+	// for publishers which cannot set the deleted field of the body.
+	deleteEventHeader = "Annotations-Deleted"
+	// deleteMessageType is the Message-Type telling the writers to drop all the PAC annotations of the content,
+	// rather than replacing them with the annotations in the message.
+	deleteMessageType = "concept-annotation-delete"
+)
+
+// isDeleteEvent reports whether the event asks for all the PAC annotations of the content to be removed,
+// with either the deleted field of the body or the Annotations-Deleted header.
+func isDeleteEvent(event PacMetadataPublishEvent, headerValue string) bool {
+	return event.Deleted || strings.EqualFold(headerValue, "true")
+}
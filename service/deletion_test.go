@@ -0,0 +1,81 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/v3"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleMessageEmitsDeleteEvents(t *testing.T) {
+	contentUUID := uuid.NewString()
+
+	tests := map[string]struct {
+		headers             map[string]string
+		body                string
+		expectedMessageType string
+		expectedDeleted     bool
+	}{
+		"deleted field": {
+			body:                fmt.Sprintf(`{"uuid": "%s", "annotations": [], "deleted": true}`, contentUUID),
+			expectedMessageType: deleteMessageType,
+			expectedDeleted:     true,
+		},
+		"delete header": {
+			headers:             map[string]string{deleteEventHeader: "true"},
+			body:                fmt.Sprintf(`{"uuid": "%s", "annotations": []}`, contentUUID),
+			expectedMessageType: deleteMessageType,
+			expectedDeleted:     true,
+		},
+		"empty annotations": {
+			body:                fmt.Sprintf(`{"uuid": "%s", "annotations": []}`, contentUUID),
+			expectedMessageType: "concept-annotation",
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			producer := &mockMessageProducer{}
+			producer.On("SendMessage", mock.Anything).Return(nil)
+			mapper := NewAnnotationMapperService(regexp.MustCompile(regexp.QuoteMeta(testSystemID)), producer, logger.NewUnstructuredLogger())
+
+			headers := map[string]string{"X-Request-Id": "tid_test", "Origin-System-Id": testSystemID}
+			for k, v := range test.headers {
+				headers[k] = v
+			}
+			mapper.HandleMessage(kafka.FTMessage{Headers: headers, Body: test.body})
+
+			require.Len(t, producer.received, 1)
+			msg := producer.received[0]
+			assert.Equal(t, test.expectedMessageType, msg.Headers["Message-Type"])
+			mapped := decodeMappedAnnotations(t, msg)
+			assert.Equal(t, contentUUID, mapped.UUID)
+			assert.Empty(t, mapped.Annotations)
+			assert.Equal(t, test.expectedDeleted, mapped.Deleted)
+		})
+	}
+}
+
+func TestHandleMessageRejectsDeleteEventsWithAnnotations(t *testing.T) {
+	producer := &mockMessageProducer{}
+	deadLetterProducer := &mockMessageProducer{}
+	deadLetterProducer.On("SendMessage", mock.Anything).Return(nil)
+	mapper := NewAnnotationMapperService(regexp.MustCompile(regexp.QuoteMeta(testSystemID)), producer, logger.NewUnstructuredLogger(),
+		WithDeadLetterProducer(deadLetterProducer),
+	)
+
+	mapper.HandleMessage(kafka.FTMessage{
+		Headers: map[string]string{"X-Request-Id": "tid_test", "Origin-System-Id": testSystemID, deleteEventHeader: "true"},
+		Body:    fmt.Sprintf(`{"uuid": "%s", "annotations": [{"predicate": "http://www.ft.com/ontology/annotation/about", "id": "http://www.ft.com/thing/%s"}]}`, uuid.NewString(), uuid.NewString()),
+	})
+
+	producer.AssertNotCalled(t, "SendMessage", mock.Anything)
+	require.Len(t, deadLetterProducer.received, 1)
+	assert.Equal(t, deadLetterStageValidation, deadLetterProducer.received[0].Headers["Dead-Letter-Stage"])
+}
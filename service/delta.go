@@ -0,0 +1,93 @@
+package service
+
+import (
+	"fmt"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const deltaMessageType = "concept-annotation-delta"
+
+// DeltaMode decides whether and how the changes to the previously emitted annotations of a content are emitted.
+type DeltaMode string
+
+const (
+	// DeltaModeOff emits only the full set of annotations.
+	DeltaModeOff DeltaMode = "off"
+	// DeltaModeTopic additionally emits the changes as a separate message to the delta topic.
+	DeltaModeTopic DeltaMode = "topic"
+	// DeltaModeField adds the changes to the delta field of the mapped annotations message.
+	DeltaModeField DeltaMode = "field"
+)
+
+func ParseDeltaMode(s string) (DeltaMode, error) {
+	switch m := DeltaMode(s); m {
+	case DeltaModeOff, DeltaModeTopic, DeltaModeField:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown delta mode %q, expected one of %s, %s or %s", s, DeltaModeOff, DeltaModeTopic, DeltaModeField)
+}
+
+// AnnotationsDelta lists the annotations added and removed since the last annotations emitted for the content.
+// When the previous annotations are not known, Initial is set and every annotation is listed as added.
+type AnnotationsDelta struct {
+	UUID    string       `json:"uuid"`
+	Added   []annotation `json:"added"`
+	Removed []annotation `json:"removed"`
+	Initial bool         `json:"initial,omitempty"`
+}
+
+func (d *AnnotationsDelta) isEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+// AnnotationHistory remembers the last annotations emitted for the most recently used content UUIDs.
+type AnnotationHistory struct {
+	cache *lru.Cache
+}
+
+// NewAnnotationHistory creates a history keeping the annotations of up to size content UUIDs in memory.
+func NewAnnotationHistory(size int) (*AnnotationHistory, error) {
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create annotation history: %w", err)
+	}
+	return &AnnotationHistory{cache: cache}, nil
+}
+
+// Delta compares the annotations with the last ones recorded for the content.
+func (h *AnnotationHistory) Delta(mapped MappedAnnotations) *AnnotationsDelta {
+	delta := &AnnotationsDelta{UUID: mapped.UUID, Added: []annotation{}, Removed: []annotation{}}
+
+	v, found := h.cache.Get(mapped.UUID)
+	if !found {
+		delta.Initial = true
+		delta.Added = append(delta.Added, mapped.Annotations...)
+		return delta
+	}
+	previous := v.([]annotation)
+
+	current := make(map[annotation]bool, len(mapped.Annotations))
+	for _, ann := range mapped.Annotations {
+		current[ann] = true
+	}
+	before := make(map[annotation]bool, len(previous))
+	for _, ann := range previous {
+		before[ann] = true
+		if !current[ann] {
+			delta.Removed = append(delta.Removed, ann)
+		}
+	}
+	for _, ann := range mapped.Annotations {
+		if !before[ann] {
+			delta.Added = append(delta.Added, ann)
+		}
+	}
+
+	return delta
+}
+
+// Record remembers the annotations emitted for the content.
+func (h *AnnotationHistory) Record(mapped MappedAnnotations) {
+	h.cache.Add(mapped.UUID, mapped.Annotations)
+}
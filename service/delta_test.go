@@ -0,0 +1,45 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDeltaMode(t *testing.T) {
+	for _, s := range []string{"off", "topic", "field"} {
+		m, err := ParseDeltaMode(s)
+		assert.NoError(t, err)
+		assert.Equal(t, DeltaMode(s), m)
+	}
+
+	_, err := ParseDeltaMode("header")
+	assert.Error(t, err)
+}
+
+func TestAnnotationHistoryDelta(t *testing.T) {
+	history, err := NewAnnotationHistory(10)
+	require.NoError(t, err)
+
+	about := annotation{Concept: concept{ID: thingURIPrefix + "1", Predicate: "about"}}
+	mentions := annotation{Concept: concept{ID: thingURIPrefix + "2", Predicate: "mentions"}}
+	classified := annotation{Concept: concept{ID: thingURIPrefix + "3", Predicate: "isClassifiedBy"}}
+
+	first := MappedAnnotations{UUID: "content", Annotations: []annotation{about, mentions}}
+	delta := history.Delta(first)
+	assert.True(t, delta.Initial, "the first delta of a content should be initial")
+	assert.Equal(t, []annotation{about, mentions}, delta.Added)
+	assert.Empty(t, delta.Removed)
+	history.Record(first)
+
+	second := MappedAnnotations{UUID: "content", Annotations: []annotation{mentions, classified}}
+	delta = history.Delta(second)
+	assert.False(t, delta.Initial)
+	assert.Equal(t, []annotation{classified}, delta.Added)
+	assert.Equal(t, []annotation{about}, delta.Removed)
+	history.Record(second)
+
+	delta = history.Delta(second)
+	assert.True(t, delta.isEmpty(), "unchanged annotations should have an empty delta")
+}
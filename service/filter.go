@@ -0,0 +1,190 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// whitelistRuleName is the name of the allow rule built from the whitelist regex.
+	whitelistRuleName = "whitelistRegex"
+	// noAllowRuleMatched is reported when a message matches neither a deny nor an allow rule.
+	noAllowRuleMatched = "no-allow-rule"
+	// originSystemIDHeader is the header matched by the filter rules which do not set one.
+	originSystemIDHeader = "Origin-System-Id"
+)
+
+// MatchType is how the pattern of a filter rule is compared with the header value.
+type MatchType string
+
+const (
+	MatchExact  MatchType = "exact"
+	MatchPrefix MatchType = "prefix"
+	MatchRegex  MatchType = "regex"
+)
+
+// FilterRuleConfig is a single allow, deny or require rule of the filter configuration file.
+type FilterRuleConfig struct {
+	Name string `yaml:"name"`
+	// Header is the message header the rule matches. The Origin-System-Id is matched if empty.
+	Header  string    `yaml:"header"`
+	Match   MatchType `yaml:"match"`
+	Pattern string    `yaml:"pattern"`
+}
+
+// FilterConfig lists the rules of the filter configuration file.
+type FilterConfig struct {
+	Allow []FilterRuleConfig `yaml:"allow"`
+	Deny  []FilterRuleConfig `yaml:"deny"`
+	// Require rules must all match the message for it to be processed.
+	Require []FilterRuleConfig `yaml:"require"`
+}
+
+// LoadFilterConfig reads the filter configuration from the given YAML or JSON file.
+func LoadFilterConfig(path string) (FilterConfig, error) {
+	var config FilterConfig
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("cannot read filter config file: %w", err)
+	}
+	if err = yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("cannot parse filter config file: %w", err)
+	}
+	return config, nil
+}
+
+// FilterRule matches a header of a message. A missing header is matched as an empty value.
+type FilterRule struct {
+	Name    string
+	Header  string
+	matches func(value string) bool
+}
+
+// NewFilterRule compiles the rule configuration.
+func NewFilterRule(config FilterRuleConfig) (FilterRule, error) {
+	if config.Name == "" {
+		return FilterRule{}, fmt.Errorf("filter rule with pattern %q has no name", config.Pattern)
+	}
+	if config.Pattern == "" {
+		return FilterRule{}, fmt.Errorf("filter rule %q has no pattern", config.Name)
+	}
+
+	rule := FilterRule{Name: config.Name, Header: config.Header}
+	if rule.Header == "" {
+		rule.Header = originSystemIDHeader
+	}
+	pattern := config.Pattern
+	switch config.Match {
+	case MatchExact:
+		rule.matches = func(value string) bool { return value == pattern }
+	case MatchPrefix:
+		rule.matches = func(value string) bool { return strings.HasPrefix(value, pattern) }
+	case MatchRegex:
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return FilterRule{}, fmt.Errorf("filter rule %q has an invalid regex: %w", config.Name, err)
+		}
+		rule.matches = regex.MatchString
+	default:
+		return FilterRule{}, fmt.Errorf("filter rule %q has an unknown match %q, expected one of %s, %s or %s", config.Name, config.Match, MatchExact, MatchPrefix, MatchRegex)
+	}
+	return rule, nil
+}
+
+func regexFilterRule(name string, regex *regexp.Regexp) FilterRule {
+	return FilterRule{Name: name, Header: originSystemIDHeader, matches: regex.MatchString}
+}
+
+// OriginFilter decides which messages are processed by their Origin-System-Id and other headers, before they are unmarshalled.
+// Deny rules are evaluated first, in order, and skip the message on the first match. Then the message is skipped
+// if it does not match any of the require rules. Otherwise the message is processed if its Origin-System-Id matches
+// a route or one of the allow rules, and skipped if it matches neither.
+type OriginFilter struct {
+	Allow   []FilterRule
+	Deny    []FilterRule
+	Require []FilterRule
+}
+
+// NewOriginFilter compiles the rules of the filter configuration.
+func NewOriginFilter(config FilterConfig) (*OriginFilter, error) {
+	filter := &OriginFilter{}
+	names := map[string]bool{whitelistRuleName: true, noAllowRuleMatched: true}
+
+	compile := func(configs []FilterRuleConfig) ([]FilterRule, error) {
+		var rules []FilterRule
+		for _, c := range configs {
+			if names[c.Name] {
+				return nil, fmt.Errorf("filter rule name %q is reserved or used more than once", c.Name)
+			}
+			names[c.Name] = true
+
+			rule, err := NewFilterRule(c)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, rule)
+		}
+		return rules, nil
+	}
+
+	var err error
+	if filter.Deny, err = compile(config.Deny); err != nil {
+		return nil, err
+	}
+	if filter.Require, err = compile(config.Require); err != nil {
+		return nil, err
+	}
+	if filter.Allow, err = compile(config.Allow); err != nil {
+		return nil, err
+	}
+	for _, rule := range filter.Allow {
+		if rule.Header != originSystemIDHeader {
+			return nil, fmt.Errorf("allow rule %q can only match the %s header", rule.Name, originSystemIDHeader)
+		}
+	}
+	return filter, nil
+}
+
+// rejected returns the name of the first deny rule matching the headers, or of the first require rule not matching them.
+func (f *OriginFilter) rejected(headers map[string]string) (string, bool) {
+	for _, rule := range f.Deny {
+		if rule.matches(headers[rule.Header]) {
+			return rule.Name, true
+		}
+	}
+	for _, rule := range f.Require {
+		if !rule.matches(headers[rule.Header]) {
+			return rule.Name, true
+		}
+	}
+	return "", false
+}
+
+// originRules returns a filter with only the rules matching the Origin-System-Id.
+func (f *OriginFilter) originRules() *OriginFilter {
+	onOrigin := func(rules []FilterRule) []FilterRule {
+		var matching []FilterRule
+		for _, rule := range rules {
+			if rule.Header == originSystemIDHeader {
+				matching = append(matching, rule)
+			}
+		}
+		return matching
+	}
+	return &OriginFilter{Allow: f.Allow, Deny: onOrigin(f.Deny), Require: onOrigin(f.Require)}
+}
+
+// allowed returns the name of the first allow rule matching the Origin-System-Id.
+func (f *OriginFilter) allowed(systemCode string) (string, bool) {
+	for _, rule := range f.Allow {
+		if rule.matches(systemCode) {
+			return rule.Name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/v3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFilterRule(t *testing.T) {
+	tests := map[string]struct {
+		config      FilterRuleConfig
+		matching    []string
+		notMatching []string
+		expectedErr bool
+	}{
+		"exact": {
+			config:      FilterRuleConfig{Name: "pac", Match: MatchExact, Pattern: "http://cmdb.ft.com/systems/pac"},
+			matching:    []string{"http://cmdb.ft.com/systems/pac"},
+			notMatching: []string{"http://cmdb.ft.com/systems/pac-test"},
+		},
+		"prefix": {
+			config:      FilterRuleConfig{Name: "pac", Match: MatchPrefix, Pattern: "http://cmdb.ft.com/systems/pac"},
+			matching:    []string{"http://cmdb.ft.com/systems/pac", "http://cmdb.ft.com/systems/pac-test"},
+			notMatching: []string{"http://cmdb.ft.com/systems/methode"},
+		},
+		"regex": {
+			config:      FilterRuleConfig{Name: "pac", Match: MatchRegex, Pattern: `^http://cmdb\.ft\.com/systems/pac(-\w+)?$`},
+			matching:    []string{"http://cmdb.ft.com/systems/pac", "http://cmdb.ft.com/systems/pac-test"},
+			notMatching: []string{"http://cmdb.ft.com/systems/pacman/x"},
+		},
+		"invalid regex": {
+			config:      FilterRuleConfig{Name: "pac", Match: MatchRegex, Pattern: "("},
+			expectedErr: true,
+		},
+		"unknown match": {
+			config:      FilterRuleConfig{Name: "pac", Match: "glob", Pattern: "*"},
+			expectedErr: true,
+		},
+		"no name": {
+			config:      FilterRuleConfig{Match: MatchExact, Pattern: "x"},
+			expectedErr: true,
+		},
+		"no pattern": {
+			config:      FilterRuleConfig{Name: "pac", Match: MatchExact},
+			expectedErr: true,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			rule, err := NewFilterRule(test.config)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			for _, value := range test.matching {
+				assert.True(t, rule.matches(value), "%s should match", value)
+			}
+			for _, value := range test.notMatching {
+				assert.False(t, rule.matches(value), "%s should not match", value)
+			}
+		})
+	}
+}
+
+func TestNewOriginFilterRejectsRepeatedNames(t *testing.T) {
+	_, err := NewOriginFilter(FilterConfig{
+		Allow: []FilterRuleConfig{{Name: "pac", Match: MatchExact, Pattern: "a"}},
+		Deny:  []FilterRuleConfig{{Name: "pac", Match: MatchExact, Pattern: "b"}},
+	})
+	assert.Error(t, err)
+
+	_, err = NewOriginFilter(FilterConfig{Allow: []FilterRuleConfig{{Name: whitelistRuleName, Match: MatchExact, Pattern: "a"}}})
+	assert.Error(t, err, "the whitelist rule name is reserved")
+}
+
+func TestNewOriginFilterRejectsAllowRulesOnOtherHeaders(t *testing.T) {
+	_, err := NewOriginFilter(FilterConfig{
+		Allow: []FilterRuleConfig{{Name: "metadata", Header: "Message-Type", Match: MatchExact, Pattern: "cms-content-published"}},
+	})
+	assert.Error(t, err)
+}
+
+func TestLoadFilterConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filter.yaml")
+	writeConfigFile(t, path, `
+allow:
+  - name: pac-systems
+    match: prefix
+    pattern: http://cmdb.ft.com/systems/pac
+deny:
+  - name: pac-test
+    match: exact
+    pattern: http://cmdb.ft.com/systems/pac-test
+`)
+
+	config, err := LoadFilterConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, FilterConfig{
+		Allow: []FilterRuleConfig{{Name: "pac-systems", Match: MatchPrefix, Pattern: "http://cmdb.ft.com/systems/pac"}},
+		Deny:  []FilterRuleConfig{{Name: "pac-test", Match: MatchExact, Pattern: "http://cmdb.ft.com/systems/pac-test"}},
+	}, config)
+}
+
+func TestRouteForAppliesFilterInOrder(t *testing.T) {
+	filter, err := NewOriginFilter(FilterConfig{
+		Allow: []FilterRuleConfig{{Name: "next-video", Match: MatchExact, Pattern: "http://cmdb.ft.com/systems/next-video-editor"}},
+		Deny: []FilterRuleConfig{
+			{Name: "pac-test", Match: MatchPrefix, Pattern: "http://cmdb.ft.com/systems/pac-test"},
+			{Name: "routed-test", Match: MatchExact, Pattern: "http://cmdb.ft.com/systems/routed-test"},
+		},
+	})
+	require.NoError(t, err)
+
+	mapper := NewAnnotationMapperService(regexp.MustCompile(`^http://cmdb\.ft\.com/systems/pac`), &mockMessageProducer{}, logger.NewUnstructuredLogger(),
+		WithOriginFilter(filter),
+		WithRoutes([]Route{{Name: "routed", OriginSystemID: regexp.MustCompile(`^http://cmdb\.ft\.com/systems/routed`), Producer: &mockMessageProducer{}}}),
+	)
+
+	tests := map[string]struct {
+		systemCode    string
+		expectedRoute string
+		expectedRule  string
+	}{
+		"whitelisted":          {systemCode: "http://cmdb.ft.com/systems/pac", expectedRoute: defaultRouteName, expectedRule: whitelistRuleName},
+		"allowed":              {systemCode: "http://cmdb.ft.com/systems/next-video-editor", expectedRoute: defaultRouteName, expectedRule: "next-video"},
+		"denied":               {systemCode: "http://cmdb.ft.com/systems/pac-test-1", expectedRule: "pac-test"},
+		"routed":               {systemCode: "http://cmdb.ft.com/systems/routed", expectedRoute: "routed", expectedRule: "routed"},
+		"denied before routes": {systemCode: "http://cmdb.ft.com/systems/routed-test", expectedRule: "routed-test"},
+		"not allowed":          {systemCode: "http://cmdb.ft.com/systems/methode", expectedRule: noAllowRuleMatched},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			route, rule := mapper.routeFor(map[string]string{originSystemIDHeader: test.systemCode})
+			assert.Equal(t, test.expectedRule, rule)
+			if test.expectedRoute == "" {
+				assert.Nil(t, route)
+				return
+			}
+			require.NotNil(t, route)
+			assert.Equal(t, test.expectedRoute, route.name)
+		})
+	}
+}
+
+func TestRouteForAppliesHeaderRules(t *testing.T) {
+	filter, err := NewOriginFilter(FilterConfig{
+		Deny: []FilterRuleConfig{{Name: "content-events", Header: "Message-Type", Match: MatchExact, Pattern: "cms-content-published"}},
+		Require: []FilterRuleConfig{
+			{Name: "json", Header: "Content-Type", Match: MatchPrefix, Pattern: "application/json"},
+			{Name: "transaction-id", Header: "X-Request-Id", Match: MatchRegex, Pattern: `^tid_`},
+		},
+	})
+	require.NoError(t, err)
+
+	mapper := NewAnnotationMapperService(regexp.MustCompile(regexp.QuoteMeta(testSystemID)), &mockMessageProducer{}, logger.NewUnstructuredLogger(),
+		WithOriginFilter(filter),
+	)
+
+	tests := map[string]struct {
+		headers      map[string]string
+		expectedRule string
+	}{
+		"metadata event": {
+			headers:      map[string]string{originSystemIDHeader: testSystemID, "Message-Type": "cms-content-metadata-published", "Content-Type": "application/json; charset=utf-8", "X-Request-Id": "tid_test"},
+			expectedRule: whitelistRuleName,
+		},
+		"denied message type": {
+			headers:      map[string]string{originSystemIDHeader: testSystemID, "Message-Type": "cms-content-published", "Content-Type": "application/json", "X-Request-Id": "tid_test"},
+			expectedRule: "content-events",
+		},
+		"required header not matching": {
+			headers:      map[string]string{originSystemIDHeader: testSystemID, "Content-Type": "text/plain", "X-Request-Id": "tid_test"},
+			expectedRule: "json",
+		},
+		"required header missing": {
+			headers:      map[string]string{originSystemIDHeader: testSystemID, "Content-Type": "application/json"},
+			expectedRule: "transaction-id",
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			route, rule := mapper.routeFor(test.headers)
+			assert.Equal(t, test.expectedRule, rule)
+			assert.Equal(t, test.expectedRule == whitelistRuleName, route != nil)
+		})
+	}
+}
+
+func TestHandleMessageSkipsMessagesRejectedByHeaderRules(t *testing.T) {
+	filter, err := NewOriginFilter(FilterConfig{
+		Deny: []FilterRuleConfig{{Name: "content-events", Header: "Message-Type", Match: MatchExact, Pattern: "cms-content-published"}},
+	})
+	require.NoError(t, err)
+
+	producer := &mockMessageProducer{}
+	deadLetterProducer := &mockMessageProducer{}
+	mapper := NewAnnotationMapperService(regexp.MustCompile(regexp.QuoteMeta(testSystemID)), producer, logger.NewUnstructuredLogger(),
+		WithOriginFilter(filter),
+		WithDeadLetterProducer(deadLetterProducer),
+	)
+
+	skipped := testutil.ToFloat64(messagesSkipped.WithLabelValues("content-events"))
+	mapper.HandleMessage(kafka.FTMessage{
+		Headers: map[string]string{"X-Request-Id": "tid_test", originSystemIDHeader: testSystemID, "Message-Type": "cms-content-published"},
+		Body:    `{"not": "a metadata event"}`,
+	})
+
+	producer.AssertNotCalled(t, "SendMessage", mock.Anything)
+	deadLetterProducer.AssertNotCalled(t, "SendMessage", mock.Anything)
+	assert.Equal(t, skipped+1, testutil.ToFloat64(messagesSkipped.WithLabelValues("content-events")), "skipped messages")
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	MapPath = "/map"
+	// maxMapRequestSize is the size limit of the /map request body, that of a Kafka message by default.
+	maxMapRequestSize = 1 << 20
+)
+
+type errorResponse struct {
+	Message    string      `json:"message"`
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+// MapHandler maps a PAC metadata publish event from the request body and responds with the annotations
+// HandleMessage would send to the queue, along with the annotations which would be dropped.
+// Nothing is written to Kafka. If the optional Origin-System-Id header is set, it is checked against the filter rules
+// on the Origin-System-Id and the routes as the message header would be, and selects the predicate mapping of the
+// matching route. The rules on other headers are not applied, as the request headers are not those of the message.
+func (mapper *AnnotationMapperService) MapHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Message: "Only POST is supported"})
+		return
+	}
+
+	predicates := mapper.predicates
+	if systemCode := r.Header.Get(originSystemIDHeader); systemCode != "" {
+		route, rule := mapper.routeForOrigin(systemCode)
+		if route == nil {
+			msg := fmt.Sprintf("Annotations published with Origin-System-Id %q would be skipped by the %q filter rule", systemCode, rule)
+			writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Message: msg})
+			return
+		}
+		predicates = route.predicates
+	}
+
+	var metadataPublishEvent PacMetadataPublishEvent
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMapRequestSize)).Decode(&metadataPublishEvent); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Message: fmt.Sprintf("Cannot unmarshal request body: %v", err)})
+		return
+	}
+	metadataPublishEvent.Deleted = isDeleteEvent(metadataPublishEvent, r.Header.Get(deleteEventHeader))
+
+	if err := validateEvent(metadataPublishEvent); err != nil {
+		response := errorResponse{Message: "The event would be rejected as invalid"}
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			response.Violations = validationErr.Violations
+		}
+		writeJSON(w, http.StatusUnprocessableEntity, response)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, mapper.mapEvent(metadataPublishEvent, predicates))
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapHandler(t *testing.T) {
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	contentUUID := uuid.NewString()
+	conceptID := uuid.NewString()
+	body := fmt.Sprintf(`{
+		"uuid":"%s",
+		"annotations":[
+			{"predicate":"http://www.ft.com/ontology/annotation/about","id":"%s"},
+			{"predicate":"http://www.ft.com/ontology/unsupported","id":"%s"}
+		]
+	}`, contentUUID, conceptID, conceptID)
+
+	tests := map[string]struct {
+		method         string
+		systemID       string
+		headers        map[string]string
+		filter         *FilterConfig
+		body           string
+		expectedStatus int
+	}{
+		"mapped": {
+			method:         http.MethodPost,
+			body:           body,
+			expectedStatus: http.StatusOK,
+		},
+		"mapped with whitelisted origin": {
+			method:         http.MethodPost,
+			systemID:       testSystemID,
+			body:           body,
+			expectedStatus: http.StatusOK,
+		},
+		"origin not whitelisted": {
+			method:         http.MethodPost,
+			systemID:       "http://cmdb.ft.com/systems/other",
+			body:           body,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		"origin denied": {
+			method:         http.MethodPost,
+			systemID:       testSystemID,
+			filter:         &FilterConfig{Deny: []FilterRuleConfig{{Name: "origin", Match: MatchExact, Pattern: testSystemID}}},
+			body:           body,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		"rules on other headers not applied": {
+			method:   http.MethodPost,
+			systemID: testSystemID,
+			headers:  map[string]string{"Content-Type": "application/x-www-form-urlencoded", "Message-Type": "cms-content-published"},
+			filter: &FilterConfig{
+				Deny:    []FilterRuleConfig{{Name: "content-events", Header: "Message-Type", Match: MatchExact, Pattern: "cms-content-published"}},
+				Require: []FilterRuleConfig{{Name: "json", Header: "Content-Type", Match: MatchPrefix, Pattern: "application/json"}},
+			},
+			body:           body,
+			expectedStatus: http.StatusOK,
+		},
+		"invalid event": {
+			method:         http.MethodPost,
+			body:           `{"uuid":"not-a-uuid"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		"invalid json": {
+			method:         http.MethodPost,
+			body:           `{"uuid":`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"body too large": {
+			method:         http.MethodPost,
+			body:           fmt.Sprintf(`{"uuid":"%s","annotations":[],"padding":"%s"}`, contentUUID, strings.Repeat("x", maxMapRequestSize)),
+			expectedStatus: http.StatusBadRequest,
+		},
+		"wrong method": {
+			method:         http.MethodGet,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			mp := &mockMessageProducer{}
+			var opts []MapperOption
+			if test.filter != nil {
+				filter, err := NewOriginFilter(*test.filter)
+				require.NoError(t, err)
+				opts = append(opts, WithOriginFilter(filter))
+			}
+			service := NewAnnotationMapperService(whitelist, mp, logger.NewUnstructuredLogger(), opts...)
+
+			req := httptest.NewRequest(test.method, MapPath, strings.NewReader(test.body))
+			if test.systemID != "" {
+				req.Header.Set("Origin-System-Id", test.systemID)
+			}
+			for name, value := range test.headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+
+			service.MapHandler(w, req)
+
+			assert.Equal(t, test.expectedStatus, w.Code)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			assert.Empty(t, mp.received, "nothing should be sent to kafka")
+			if test.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var result MappingResult
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+			assert.Equal(t, contentUUID, result.UUID)
+			require.Len(t, result.Annotations, 1)
+			assert.Equal(t, "about", result.Annotations[0].Concept.Predicate)
+			require.Len(t, result.Dropped, 1)
+			assert.Equal(t, "http://www.ft.com/ontology/unsupported", result.Dropped[0].Annotation.Predicate)
+			assert.Equal(t, dropReasonUnsupportedPredicate, result.Dropped[0].Reason)
+			require.Len(t, result.Normalised, 1)
+			assert.Equal(t, NormalisedConceptID{Original: conceptID, Normalised: thingURIPrefix + conceptID}, result.Normalised[0])
+		})
+	}
+}
@@ -4,6 +4,28 @@ package service
 type MappedAnnotations struct {
 	UUID        string       `json:"uuid"`
 	Annotations []annotation `json:"annotations"`
+	// Deleted is set when all the PAC annotations of the content are removed
+	Deleted bool `json:"deleted,omitempty"`
+	// Delta is only set when the annotation changes are emitted as a field
+	Delta *AnnotationsDelta `json:"delta,omitempty"`
+	// Provenance is only set when the PAC event says who submitted the annotations
+	Provenance *Provenance `json:"provenance,omitempty"`
+}
+
+// Provenance records who curated the annotation set in PAC, when and at which stage of the PAC lifecycle.
+// The values are copied as they were published.
+type Provenance struct {
+	SubmittedBy string `json:"submittedBy,omitempty"`
+	SubmittedAt string `json:"submittedAt,omitempty"`
+	Lifecycle   string `json:"lifecycle,omitempty"`
+}
+
+// newProvenance returns the provenance of the event, or nil if the event has none of the provenance fields.
+func newProvenance(event PacMetadataPublishEvent) *Provenance {
+	if event.SubmittedBy == "" && event.SubmittedAt == "" && event.Lifecycle == "" {
+		return nil
+	}
+	return &Provenance{SubmittedBy: event.SubmittedBy, SubmittedAt: event.SubmittedAt, Lifecycle: event.Lifecycle}
 }
 
 type annotation struct {
@@ -14,3 +36,18 @@ type concept struct {
 	ID        string `json:"id"`
 	Predicate string `json:"predicate"`
 }
+
+const dropReasonUnsupportedPredicate = "unsupported predicate"
+
+// MappingResult is the outcome of mapping a single PAC metadata publish event
+type MappingResult struct {
+	MappedAnnotations
+	Dropped    []DroppedAnnotation   `json:"dropped"`
+	Normalised []NormalisedConceptID `json:"normalised"`
+}
+
+// DroppedAnnotation is a PAC annotation which was not mapped, along with the reason
+type DroppedAnnotation struct {
+	Annotation PacMetadataAnnotation `json:"annotation"`
+	Reason     string                `json:"reason"`
+}
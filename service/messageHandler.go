@@ -1,14 +1,23 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"regexp"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/Financial-Times/go-logger/v2"
 
 	"github.com/Financial-Times/kafka-client-go/v3"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const messageTimestampDateFormat = "2006-01-02T15:04:05.000Z"
@@ -16,75 +25,337 @@ const messageTimestampDateFormat = "2006-01-02T15:04:05.000Z"
 const mapperEvent = "Map"
 const annotationsType = "Annotations"
 
-var predicates = map[string]string{
-	"http://www.ft.com/ontology/hasBrand":                      "hasBrand",
-	"http://www.ft.com/ontology/classification/isClassifiedBy": "isClassifiedBy",
-	"http://www.ft.com/ontology/implicitlyClassifiedBy":        "implicitlyClassifiedBy",
-	"http://www.ft.com/ontology/annotation/hasAuthor":          "hasAuthor",
-	"http://www.ft.com/ontology/hasContributor":                "hasContributor",
-	"http://www.ft.com/ontology/annotation/about":              "about",
-	"http://www.ft.com/ontology/hasDisplayTag":                 "hasDisplayTag",
-	"http://www.ft.com/ontology/annotation/mentions":           "mentions",
-}
-
 type kafkaProducer interface {
 	SendMessage(message kafka.FTMessage) error
 }
 
 type AnnotationMapperService struct {
-	whitelist       *regexp.Regexp
-	messageProducer kafkaProducer
-	log             *logger.UPPLogger
+	whitelist          *regexp.Regexp
+	filter             *OriginFilter
+	messageProducer    kafkaProducer
+	deadLetterProducer kafkaProducer
+	sourceTopic        string
+	predicates         *PredicateMapping
+	conflicts          ConflictResolution
+	routes             []Route
+	messageTimeout     time.Duration
+	stalePolicy        StalePolicy
+	timestamps         *TimestampTracker
+	passThroughHeaders []string
+	deltaMode          DeltaMode
+	history            *AnnotationHistory
+	deltaProducer      kafkaProducer
+	tracer             trace.Tracer
+	auditProducer      kafkaProducer
+	unmappablePolicy   UnmappablePolicy
+	inFlight           *sync.WaitGroup
+	log                *logger.UPPLogger
 }
 
-func NewAnnotationMapperService(whitelist *regexp.Regexp, messageProducer kafkaProducer, log *logger.UPPLogger) *AnnotationMapperService {
-	return &AnnotationMapperService{whitelist, messageProducer, log}
+// MapperOption configures optional behaviour of the AnnotationMapperService.
+type MapperOption func(mapper *AnnotationMapperService)
+
+// WithPredicateMapping replaces the built-in predicate mapping.
+func WithPredicateMapping(predicates *PredicateMapping) MapperOption {
+	return func(mapper *AnnotationMapperService) {
+		mapper.predicates = predicates
+	}
+}
+
+// WithConflictResolution sets how annotations of the same concept with conflicting predicates are resolved.
+// All of them are kept by default.
+func WithConflictResolution(conflicts ConflictResolution) MapperOption {
+	return func(mapper *AnnotationMapperService) {
+		mapper.conflicts = conflicts
+	}
+}
+
+// WithOriginFilter adds deny and require rules for the message headers, and allow rules besides the whitelist
+// for the Origin-System-Id of the messages.
+func WithOriginFilter(filter *OriginFilter) MapperOption {
+	return func(mapper *AnnotationMapperService) {
+		mapper.filter.Deny = append(mapper.filter.Deny, filter.Deny...)
+		mapper.filter.Require = append(mapper.filter.Require, filter.Require...)
+		mapper.filter.Allow = append(mapper.filter.Allow, filter.Allow...)
+	}
+}
+
+// WithRoutes sends the annotations of messages matching a route to the route producer instead of the service one.
+// Routes are evaluated in order and take precedence over the whitelist.
+func WithRoutes(routes []Route) MapperOption {
+	return func(mapper *AnnotationMapperService) {
+		mapper.routes = routes
+	}
+}
+
+// WithDeadLetterProducer enables republishing of messages which cannot be processed.
+func WithDeadLetterProducer(producer kafkaProducer) MapperOption {
+	return func(mapper *AnnotationMapperService) {
+		mapper.deadLetterProducer = producer
+	}
+}
+
+// WithSourceTopic sets the topic the messages are consumed from, which is recorded in the lineage and dead-letter headers
+// unless the message source is carried by the context.
+func WithSourceTopic(topic string) MapperOption {
+	return func(mapper *AnnotationMapperService) {
+		mapper.sourceTopic = topic
+	}
+}
+
+// WithHeaderPassThrough copies the listed headers of the consumed message to the mapped annotations message.
+// Headers set by the mapper itself are never overwritten.
+func WithHeaderPassThrough(headers []string) MapperOption {
+	return func(mapper *AnnotationMapperService) {
+		mapper.passThroughHeaders = headers
+	}
+}
+
+// WithMessageTimeout bounds how long a single message may be handled for, including retries of the producer.
+// A zero timeout, the default, leaves messages unbounded.
+func WithMessageTimeout(timeout time.Duration) MapperOption {
+	return func(mapper *AnnotationMapperService) {
+		mapper.messageTimeout = timeout
+	}
+}
+
+// WithStaleEventDetection compares the Message-Timestamp of every event with the last one emitted for the same content,
+// and skips or flags the older events according to the policy.
+func WithStaleEventDetection(policy StalePolicy, timestamps *TimestampTracker) MapperOption {
+	return func(mapper *AnnotationMapperService) {
+		mapper.stalePolicy = policy
+		mapper.timestamps = timestamps
+	}
+}
+
+// WithAnnotationDiffing emits the annotations added and removed since the last annotations emitted for the same content,
+// either as a field of the mapped annotations message or, in topic mode, as a separate message sent with the delta producer.
+func WithAnnotationDiffing(mode DeltaMode, history *AnnotationHistory, deltaProducer kafkaProducer) MapperOption {
+	return func(mapper *AnnotationMapperService) {
+		mapper.deltaMode = mode
+		mapper.history = history
+		mapper.deltaProducer = deltaProducer
+	}
+}
+
+// WithAuditProducer publishes the mapping report of every mapped event, e.g. for editorial QA dashboards.
+func WithAuditProducer(producer kafkaProducer) MapperOption {
+	return func(mapper *AnnotationMapperService) {
+		mapper.auditProducer = producer
+	}
+}
+
+// WithUnmappablePolicy sets what happens to events with annotations none of which could be mapped.
+// They are emitted with an empty list of annotations by default.
+func WithUnmappablePolicy(policy UnmappablePolicy) MapperOption {
+	return func(mapper *AnnotationMapperService) {
+		mapper.unmappablePolicy = policy
+	}
+}
+
+// WithTracerProvider sets the provider of the tracer recording the message handling spans,
+// instead of the global one.
+func WithTracerProvider(provider trace.TracerProvider) MapperOption {
+	return func(mapper *AnnotationMapperService) {
+		mapper.tracer = provider.Tracer(tracerName)
+	}
+}
+
+func NewAnnotationMapperService(whitelist *regexp.Regexp, messageProducer kafkaProducer, log *logger.UPPLogger, opts ...MapperOption) *AnnotationMapperService {
+	mapper := &AnnotationMapperService{
+		whitelist:       whitelist,
+		messageProducer: messageProducer,
+		predicates:      DefaultPredicateMapping(),
+		filter:          &OriginFilter{},
+		inFlight:        &sync.WaitGroup{},
+		tracer:          otel.Tracer(tracerName),
+		log:             log,
+	}
+
+	if whitelist != nil {
+		mapper.filter.Allow = append(mapper.filter.Allow, regexFilterRule(whitelistRuleName, whitelist))
+	}
+	for _, opt := range opts {
+		opt(mapper)
+	}
+
+	return mapper
 }
 
 func (mapper *AnnotationMapperService) HandleMessage(msg kafka.FTMessage) {
-	tid, found := msg.Headers["X-Request-Id"]
-	if !found {
-		tid = "unknown"
+	mapper.HandleMessageWithContext(context.Background(), msg)
+}
+
+// HandleMessageWithContext maps the message and sends the annotations to the queue, giving up once the context is done
+// or the message timeout has elapsed. Messages which time out or are cancelled by the context, e.g. on shutdown,
+// are sent to the dead-letter queue, as their offset is committed once the handler returns.
+func (mapper *AnnotationMapperService) HandleMessageWithContext(ctx context.Context, msg kafka.FTMessage) {
+	mapper.inFlight.Add(1)
+	defer mapper.inFlight.Done()
+
+	if mapper.messageTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, mapper.messageTimeout)
+		defer cancel()
+	}
+
+	messagesConsumed.Inc()
+	timer := prometheus.NewTimer(handleDuration)
+	defer timer.ObserveDuration()
+
+	ctx = traceContext.Extract(ctx, propagation.MapCarrier(msg.Headers))
+	ctx, span := mapper.tracer.Start(ctx, "HandleMessage", trace.WithSpanKind(trace.SpanKindConsumer))
+	var handleErr error
+	defer func() { endSpan(span, handleErr) }()
+
+	tid := msg.Headers["X-Request-Id"]
+	if tid == "" {
+		tid = newTransactionID()
+		msg = withGeneratedTransactionID(msg, tid)
+		mapper.log.WithTransactionID(tid).Info("Generated a transaction id as the message has no X-Request-Id")
 	}
 
 	requestLog := mapper.log.WithTransactionID(tid)
-	if mapper.whitelist == nil {
+	systemCode := msg.Headers[originSystemIDHeader]
+	span.SetAttributes(
+		attribute.String("messaging.system", "kafka"),
+		attribute.String("messaging.source.name", mapper.sourceTopic),
+		attribute.String("transaction_id", tid),
+		attribute.String("origin_system_id", systemCode),
+	)
+
+	_, filterSpan := mapper.tracer.Start(ctx, "FilterMessage")
+	route, rule := mapper.routeFor(msg.Headers)
+	filterSpan.SetAttributes(attribute.Bool("skipped", route == nil), attribute.String("rule", rule))
+	if route != nil {
+		filterSpan.SetAttributes(attribute.String("route", route.name))
+	}
+	filterSpan.End()
+	if route == nil && rule == noAllowRuleMatched && len(mapper.filter.Allow) == 0 && len(mapper.routes) == 0 {
 		requestLog.Error("Skipping this message because the whitelist is invalid.")
 		return
 	}
-
-	systemCode := msg.Headers["Origin-System-Id"]
-	if !mapper.whitelist.MatchString(systemCode) {
-		requestLog.Infof("Skipping annotations published with Origin-System-Id \"%v\". It does not match the configured whitelist.", systemCode)
+	if route == nil {
+		requestLog.WithField("rule", rule).WithField("messageType", msg.Headers["Message-Type"]).
+			Infof("Skipping message published with Origin-System-Id \"%v\". It does not match the configured filter.", systemCode)
+		messagesSkipped.WithLabelValues(rule).Inc()
 		return
 	}
+	requestLog = requestLog.WithField("route", route.name).WithField("rule", rule)
+
+	// only the messages passing the filter are reported, as the others are not metadata publish events of PAC
+	report := newMappingReport(tid, systemCode)
+	report.Route = route.name
+	defer mapper.reportMapping(report)
 
 	var metadataPublishEvent PacMetadataPublishEvent
+	_, unmarshalSpan := mapper.tracer.Start(ctx, "UnmarshalMessage")
 	err := json.Unmarshal([]byte(msg.Body), &metadataPublishEvent)
+	endSpan(unmarshalSpan, err)
 	if err != nil {
+		handleErr = err
 		mapper.log.WithMonitoringEvent(mapperEvent, tid, annotationsType).
 			WithValidFlag(false).
 			WithError(err).
 			Error("Cannot unmarshal message body")
+		unmarshalFailures.Inc()
+		report.rejected(err)
+		mapper.sendToDeadLetter(ctx, msg, tid, deadLetterStageUnmarshal, err)
+		return
+	}
+
+	metadataPublishEvent.Deleted = isDeleteEvent(metadataPublishEvent, msg.Headers[deleteEventHeader])
+
+	_, validateSpan := mapper.tracer.Start(ctx, "ValidateEvent")
+	err = validateEvent(metadataPublishEvent)
+	endSpan(validateSpan, err)
+	if err != nil {
+		handleErr = err
+		mapper.log.WithMonitoringEvent(mapperEvent, tid, annotationsType).
+			WithUUID(metadataPublishEvent.UUID).
+			WithValidFlag(false).
+			WithError(err).
+			Error("Rejecting invalid metadata publish event")
+		countViolations(err)
+		report.UUID = metadataPublishEvent.UUID
+		report.rejected(err)
+		mapper.sendToDeadLetter(ctx, msg, tid, deadLetterStageValidation, err)
 		return
 	}
 
 	requestLog = requestLog.WithUUID(metadataPublishEvent.UUID)
+	report.UUID = metadataPublishEvent.UUID
+	report.Deleted = metadataPublishEvent.Deleted
+	if metadataPublishEvent.Deleted {
+		requestLog = requestLog.WithField("deleted", true)
+		deleteEvents.Inc()
+	}
 	requestLog.Info("Processing metadata publish event")
+	span.SetAttributes(
+		attribute.String("content_uuid", metadataPublishEvent.UUID),
+		attribute.Bool("deleted", metadataPublishEvent.Deleted),
+	)
 
-	annotations := []annotation{}
-	for _, value := range metadataPublishEvent.Annotations {
-		ann := mapper.buildAnnotation(value)
-		if ann != nil {
-			annotations = append(annotations, *ann)
-		} else {
-			requestLog.WithField("metadata", value).Warn("metadata for an unsupported predicate was not mapped")
+	eventTime, stale := mapper.checkStaleness(metadataPublishEvent.UUID, msg.Headers["Message-Timestamp"], requestLog)
+	if stale && mapper.stalePolicy == StalePolicySkip {
+		requestLog.Warn("Skipping metadata publish event older than the last one emitted for the content")
+		staleEvents.WithLabelValues(string(StalePolicySkip)).Inc()
+		report.skipped("stale event")
+		return
+	}
+
+	_, mapSpan := mapper.tracer.Start(ctx, "MapAnnotations")
+	result := mapper.mapEvent(metadataPublishEvent, route.predicates)
+	mapSpan.SetAttributes(
+		attribute.Int("annotations.mapped", len(result.Annotations)),
+		attribute.Int("annotations.dropped", len(result.Dropped)),
+	)
+	mapSpan.End()
+	for _, dropped := range result.Dropped {
+		switch dropped.Reason {
+		case dropReasonUnsupportedPredicate:
+			unsupportedPredicates.WithLabelValues(dropped.Annotation.Predicate).Inc()
+		case dropReasonDuplicate:
+			duplicateAnnotations.Inc()
+		case dropReasonConflictingPredicate:
+			conflictingAnnotations.Inc()
+		}
+	}
+	if len(result.Normalised) > 0 {
+		requestLog.WithField("normalised", result.Normalised).Debug("Rewrote concept ids to canonical thing URIs")
+		conceptIDsNormalised.Add(float64(len(result.Normalised)))
+	}
+
+	report.addMapping(metadataPublishEvent, result)
+
+	if isUnmappable(metadataPublishEvent, result) {
+		switch mapper.unmappablePolicy {
+		case UnmappablePolicySkip:
+			requestLog.Warn("Skipping metadata publish event as none of its annotations could be mapped")
+			unmappableEvents.WithLabelValues(string(UnmappablePolicySkip)).Inc()
+			report.skipped(errNoMappableAnnotations.Error())
+			return
+		case UnmappablePolicyDeadLetter:
+			requestLog.Warn("Rejecting metadata publish event as none of its annotations could be mapped")
+			unmappableEvents.WithLabelValues(string(UnmappablePolicyDeadLetter)).Inc()
+			report.Status = reportStatusDeadLettered
+			report.Reason = errNoMappableAnnotations.Error()
+			mapper.sendToDeadLetter(ctx, msg, tid, deadLetterStageUnmappable, errNoMappableAnnotations)
+			return
+		default:
+			unmappableEvents.WithLabelValues(string(UnmappablePolicyEmit)).Inc()
 		}
 	}
 
-	mappedAnnotations := MappedAnnotations{UUID: metadataPublishEvent.UUID, Annotations: annotations}
+	var delta *AnnotationsDelta
+	if mapper.history != nil && (mapper.deltaMode == DeltaModeTopic || mapper.deltaMode == DeltaModeField) {
+		delta = mapper.history.Delta(result.MappedAnnotations)
+		if mapper.deltaMode == DeltaModeField {
+			result.Delta = delta
+		}
+	}
 
-	marshalledAnnotations, err := json.Marshal(mappedAnnotations)
+	marshalledAnnotations, err := json.Marshal(result.MappedAnnotations)
 	if err != nil {
 		mapper.log.WithMonitoringEvent(mapperEvent, tid, annotationsType).
 			WithUUID(metadataPublishEvent.UUID).
@@ -94,31 +365,200 @@ func (mapper *AnnotationMapperService) HandleMessage(msg kafka.FTMessage) {
 		return
 	}
 
-	var headers = buildMappedAnnotationsHeader(msg.Headers)
+	sendCtx, sendSpan := mapper.tracer.Start(ctx, "SendMessage", trace.WithSpanKind(trace.SpanKindProducer))
+	sendSpan.SetAttributes(attribute.String("route", route.name))
+	var headers = mapper.buildMappedAnnotationsHeader(sendCtx, msg.Headers)
+	if stale {
+		requestLog.Warn("Flagging metadata publish event older than the last one emitted for the content")
+		staleEvents.WithLabelValues(string(StalePolicyFlag)).Inc()
+		headers[staleEventHeader] = "true"
+	}
+	if metadataPublishEvent.Deleted {
+		headers["Message-Type"] = deleteMessageType
+	}
 	message := kafka.FTMessage{Headers: headers, Body: string(marshalledAnnotations)}
-	err = mapper.messageProducer.SendMessage(message)
+	err = sendMessage(sendCtx, route.producer, message)
+	endSpan(sendSpan, err)
+	if err != nil {
+		handleErr = err
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		mapper.log.WithMonitoringEvent(mapperEvent, tid, annotationsType).
+			WithUUID(metadataPublishEvent.UUID).
+			WithValidFlag(true).
+			WithError(err).
+			Error("Timed out sending concept annotations to queue")
+		messagesProduced.WithLabelValues(produceStatusTimeout).Inc()
+		report.Status = produceStatusTimeout
+		mapper.sendToDeadLetter(ctx, msg, tid, deadLetterStageTimeout, err)
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		mapper.log.WithMonitoringEvent(mapperEvent, tid, annotationsType).
+			WithUUID(metadataPublishEvent.UUID).
+			WithValidFlag(true).
+			WithError(err).
+			Error("Cancelled sending concept annotations to queue")
+		messagesProduced.WithLabelValues(produceStatusCancel).Inc()
+		report.Status = produceStatusCancel
+		mapper.sendToDeadLetter(ctx, msg, tid, deadLetterStageCancelled, err)
+		return
+	}
 	if err != nil {
 		mapper.log.WithMonitoringEvent(mapperEvent, tid, annotationsType).
 			WithUUID(metadataPublishEvent.UUID).
 			WithValidFlag(true).
 			WithError(err).
 			Error("Error sending concept annotations to queue")
+		messagesProduced.WithLabelValues(produceStatusFailure).Inc()
+		mapper.sendToDeadLetter(ctx, msg, tid, deadLetterStageProduce, err)
 		return
 	}
 
+	messagesProduced.WithLabelValues(produceStatusSuccess).Inc()
+	report.Status = produceStatusSuccess
+	mapper.recordTimestamp(metadataPublishEvent.UUID, eventTime, requestLog)
+	if delta != nil {
+		mapper.history.Record(result.MappedAnnotations)
+		if mapper.deltaMode == DeltaModeTopic && !delta.isEmpty() {
+			mapper.sendDelta(ctx, headers, delta, requestLog)
+		}
+	}
 	mapper.log.WithMonitoringEvent(mapperEvent, tid, annotationsType).
 		WithUUID(metadataPublishEvent.UUID).
 		WithValidFlag(true).
 		Info("Sent annotation message to queue")
 }
 
-func (mapper *AnnotationMapperService) buildAnnotation(metadata PacMetadataAnnotation) *annotation {
+// Drain waits until the messages being handled have been processed, or until the context is done.
+// Message consumption should be stopped before draining, otherwise Drain may never return before the context is done.
+func (mapper *AnnotationMapperService) Drain(ctx context.Context) error {
+	drained := make(chan struct{})
+	go func() {
+		mapper.inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sendDelta sends the annotation changes to the delta topic, with the headers of the mapped annotations message.
+func (mapper *AnnotationMapperService) sendDelta(ctx context.Context, annotationsHeaders map[string]string, delta *AnnotationsDelta, requestLog *logger.LogEntry) {
+	body, err := json.Marshal(delta)
+	if err != nil {
+		requestLog.WithError(err).Error("Error marshalling the annotations delta")
+		return
+	}
+
+	headers := make(map[string]string, len(annotationsHeaders))
+	for k, v := range annotationsHeaders {
+		headers[k] = v
+	}
+	headers["Message-Id"] = uuid.NewString()
+	headers["Message-Type"] = deltaMessageType
+
+	if err = sendMessage(ctx, mapper.deltaProducer, kafka.FTMessage{Headers: headers, Body: string(body)}); err != nil {
+		requestLog.WithError(err).Error("Error sending annotations delta to queue")
+		deltasProduced.WithLabelValues(produceStatusFailure).Inc()
+		return
+	}
+
+	deltasProduced.WithLabelValues(produceStatusSuccess).Inc()
+	requestLog.WithField("added", len(delta.Added)).
+		WithField("removed", len(delta.Removed)).
+		Info("Sent annotations delta to queue")
+}
+
+// checkStaleness parses the Message-Timestamp of the event and reports whether it is older than the last event emitted
+// for the content. Events without a valid timestamp are never stale.
+func (mapper *AnnotationMapperService) checkStaleness(contentUUID string, messageTimestamp string, requestLog *logger.LogEntry) (time.Time, bool) {
+	if mapper.timestamps == nil || mapper.stalePolicy == "" || mapper.stalePolicy == StalePolicyOff {
+		return time.Time{}, false
+	}
+
+	eventTime, err := time.Parse(messageTimestampDateFormat, messageTimestamp)
+	if err != nil {
+		requestLog.WithError(err).Warn("Cannot check whether the event is stale as its Message-Timestamp is invalid")
+		return time.Time{}, false
+	}
+
+	lastSeen, found := mapper.timestamps.LastSeen(contentUUID)
+	return eventTime, found && eventTime.Before(lastSeen)
+}
+
+func (mapper *AnnotationMapperService) recordTimestamp(contentUUID string, eventTime time.Time, requestLog *logger.LogEntry) {
+	if mapper.timestamps == nil || eventTime.IsZero() {
+		return
+	}
+	if err := mapper.timestamps.Record(contentUUID, eventTime); err != nil {
+		requestLog.WithError(err).Error("Cannot record the timestamp of the emitted event")
+	}
+}
+
+func countViolations(err error) {
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		return
+	}
+
+	rules := map[string]bool{}
+	for _, v := range validationErr.Violations {
+		rules[v.Rule] = true
+	}
+	for rule := range rules {
+		invalidEvents.WithLabelValues(rule).Inc()
+	}
+}
+
+// mapEvent maps the annotations of a PAC metadata publish event to UPP annotations, recording those which are dropped
+// because of an unsupported predicate, a duplicate or a conflicting predicate.
+func (mapper *AnnotationMapperService) mapEvent(event PacMetadataPublishEvent, predicates *PredicateMapping) MappingResult {
+	result := MappingResult{
+		MappedAnnotations: MappedAnnotations{UUID: event.UUID, Annotations: []annotation{}, Deleted: event.Deleted, Provenance: newProvenance(event)},
+		Dropped:           []DroppedAnnotation{},
+		Normalised:        []NormalisedConceptID{},
+	}
+
+	var candidates []mappedCandidate
+	for _, value := range event.Annotations {
+		ann := buildAnnotation(value, predicates)
+		if ann != nil {
+			candidates = append(candidates, mappedCandidate{source: value, mapped: *ann})
+		} else {
+			result.Dropped = append(result.Dropped, DroppedAnnotation{Annotation: value, Reason: dropReasonUnsupportedPredicate})
+		}
+	}
+
+	candidates, dropped := mapper.conflicts.resolve(candidates)
+	result.Dropped = append(result.Dropped, dropped...)
+
+	for _, c := range candidates {
+		result.Annotations = append(result.Annotations, c.mapped)
+		if c.mapped.Concept.ID != c.source.ConceptId {
+			result.Normalised = append(result.Normalised, NormalisedConceptID{Original: c.source.ConceptId, Normalised: c.mapped.Concept.ID})
+		}
+	}
+
+	return result
+}
+
+func buildAnnotation(metadata PacMetadataAnnotation, predicates *PredicateMapping) *annotation {
 	var ann *annotation
 
-	if predicate, found := predicates[metadata.Predicate]; found {
+	if predicate, found := predicates.Lookup(metadata.Predicate); found {
+		conceptID, err := normaliseConceptID(metadata.ConceptId)
+		if err != nil {
+			return nil
+		}
+
 		ann = &annotation{
 			Concept: concept{
-				ID:        metadata.ConceptId,
+				ID:        conceptID,
 				Predicate: predicate,
 			},
 		}
@@ -127,8 +567,11 @@ func (mapper *AnnotationMapperService) buildAnnotation(metadata PacMetadataAnnot
 	return ann
 }
 
-func buildMappedAnnotationsHeader(publishEventHeaders map[string]string) map[string]string {
-	return map[string]string{
+// buildMappedAnnotationsHeader sets the headers of the mapped annotations message, including the lineage headers which
+// trace it back to the consumed message and the trace context of the span in the context,
+// and copies the pass-through headers of the consumed message.
+func (mapper *AnnotationMapperService) buildMappedAnnotationsHeader(ctx context.Context, publishEventHeaders map[string]string) map[string]string {
+	headers := map[string]string{
 		"Message-Id":        uuid.NewString(),
 		"Message-Type":      "concept-annotation",
 		"Content-Type":      publishEventHeaders["Content-Type"],
@@ -136,4 +579,44 @@ func buildMappedAnnotationsHeader(publishEventHeaders map[string]string) map[str
 		"Origin-System-Id":  publishEventHeaders["Origin-System-Id"],
 		"Message-Timestamp": time.Now().Format(messageTimestampDateFormat),
 	}
+
+	if generated, found := publishEventHeaders[generatedTransactionIDHeader]; found {
+		headers[generatedTransactionIDHeader] = generated
+	}
+	if id, found := publishEventHeaders["Message-Id"]; found {
+		headers["Original-Message-Id"] = id
+	}
+	if timestamp, found := publishEventHeaders["Message-Timestamp"]; found {
+		headers["Original-Message-Timestamp"] = timestamp
+	}
+	mapper.addSourceHeaders(ctx, headers, "Source-")
+	traceContext.Inject(ctx, propagation.MapCarrier(headers))
+
+	for _, name := range mapper.passThroughHeaders {
+		if _, set := headers[name]; set {
+			continue
+		}
+		if value, found := publishEventHeaders[name]; found {
+			headers[name] = value
+		}
+	}
+
+	return headers
+}
+
+// addSourceHeaders records the topic, partition and offset the message was consumed from, as far as they are known,
+// in headers with the given prefix.
+func (mapper *AnnotationMapperService) addSourceHeaders(ctx context.Context, headers map[string]string, prefix string) {
+	source, found := messageSourceFrom(ctx)
+	if source.Topic == "" {
+		source.Topic = mapper.sourceTopic
+	}
+
+	if source.Topic != "" {
+		headers[prefix+"Topic"] = source.Topic
+	}
+	if found {
+		headers[prefix+"Partition"] = strconv.FormatInt(int64(source.Partition), 10)
+		headers[prefix+"Offset"] = strconv.FormatInt(source.Offset, 10)
+	}
 }
@@ -1,17 +1,22 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Financial-Times/go-logger/v2"
 
 	"github.com/Financial-Times/kafka-client-go/v3"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -81,8 +86,8 @@ func TestMessageMapped(t *testing.T) {
 			PredicateURI:    "http://www.ft.com/ontology/hasDisplayTag",
 			PredicateMapped: "hasDisplayTag",
 		},
-		"invalid-predicate": {
-			PredicateURI: "invalid predicate",
+		"unsupported-predicate": {
+			PredicateURI: "http://www.ft.com/ontology/unsupported",
 			ShouldSkip:   true,
 		},
 		"hasBrand": {
@@ -141,7 +146,7 @@ func TestMessageMapped(t *testing.T) {
 
 			annotation := actualAnnotations[0]
 			assert.Equal(t, test.PredicateMapped, annotation.Concept.Predicate)
-			assert.Equal(t, annotationID, annotation.Concept.ID)
+			assert.Equal(t, thingURIPrefix+annotationID, annotation.Concept.ID, "concept id should be normalised")
 
 		})
 	}
@@ -197,11 +202,11 @@ func TestMessageProducerError(t *testing.T) {
 		"submittedBy":"test-user",
 		"annotations":[
 		    {
-		        "predicate":"foo",
-		        "id":"bar"
+		        "predicate":"http://www.ft.com/ontology/annotation/about",
+		        "id":"%s"
 		    }
 		]
-		}`, contentUUID),
+		}`, contentUUID, uuid.NewString()),
 	}
 
 	service.HandleMessage(inbound)
@@ -224,3 +229,693 @@ func TestNilWhitelistIsIgnoredWithErrorLog(t *testing.T) {
 
 	assert.Empty(t, mp.received)
 }
+
+func TestUnmarshalFailureIsSentToDeadLetter(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	mp := &mockMessageProducer{}
+	dlp := &mockMessageProducer{}
+	dlp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+	service := NewAnnotationMapperService(whitelist, mp, log, WithDeadLetterProducer(dlp), WithSourceTopic("test-topic"))
+	inbound := kafka.FTMessage{
+		Headers: map[string]string{
+			"Origin-System-Id": testSystemID,
+			"X-Request-Id":     testTxID,
+		},
+		Body: `{"foo":"bar"`,
+	}
+
+	service.HandleMessageWithContext(ContextWithMessageSource(context.Background(), MessageSource{Partition: 1, Offset: 7}), inbound)
+	assert.Empty(t, mp.received)
+	require.Len(t, dlp.received, 1, "messages sent to dead-letter producer")
+
+	actual := dlp.received[0]
+	assert.Equal(t, inbound.Body, actual.Body, "original body should be republished")
+	assert.Equal(t, testTxID, actual.Headers["X-Request-Id"], "original headers should be preserved")
+	assert.Equal(t, deadLetterStageUnmarshal, actual.Headers["Dead-Letter-Stage"])
+	assert.Equal(t, "test-topic", actual.Headers["Dead-Letter-Source-Topic"])
+	assert.Equal(t, "1", actual.Headers["Dead-Letter-Source-Partition"])
+	assert.Equal(t, "7", actual.Headers["Dead-Letter-Source-Offset"])
+	assert.Equal(t, "unexpected end of JSON input", actual.Headers["Dead-Letter-Error"])
+	assert.NotContains(t, inbound.Headers, "Dead-Letter-Stage", "inbound headers should not be modified")
+}
+
+func TestProducerFailureIsSentToDeadLetter(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	mp := &mockMessageProducer{}
+	mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(errors.New("can't \"send\""))
+	dlp := &mockMessageProducer{}
+	dlp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+	service := NewAnnotationMapperService(whitelist, mp, log, WithDeadLetterProducer(dlp), WithSourceTopic("test-topic"))
+	inbound := kafka.FTMessage{
+		Headers: map[string]string{"Origin-System-Id": testSystemID},
+		Body:    fmt.Sprintf(`{"uuid":"%s","annotations":[]}`, uuid.NewString()),
+	}
+
+	service.HandleMessage(inbound)
+	require.Len(t, dlp.received, 1, "messages sent to dead-letter producer")
+
+	actual := dlp.received[0]
+	assert.Equal(t, inbound.Body, actual.Body)
+	assert.Equal(t, deadLetterStageProduce, actual.Headers["Dead-Letter-Stage"])
+	assert.Equal(t, "can_t _send_", actual.Headers["Dead-Letter-Error"], "error should only contain header safe characters")
+}
+
+func TestHandleMessageRecordsMetrics(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	mp := &mockMessageProducer{}
+	mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+	service := NewAnnotationMapperService(whitelist, mp, log)
+
+	unsupportedPredicate := "http://www.ft.com/ontology/metricsTest"
+	consumed := testutil.ToFloat64(messagesConsumed)
+	skipped := testutil.ToFloat64(messagesSkipped.WithLabelValues(noAllowRuleMatched))
+	unmarshalFailed := testutil.ToFloat64(unmarshalFailures)
+	unsupported := testutil.ToFloat64(unsupportedPredicates.WithLabelValues(unsupportedPredicate))
+	produced := testutil.ToFloat64(messagesProduced.WithLabelValues(produceStatusSuccess))
+	handled := histogramSampleCount(t, handleDuration)
+
+	service.HandleMessage(kafka.FTMessage{Headers: map[string]string{"Origin-System-Id": "http://cmdb.ft.com/systems/other"}})
+	service.HandleMessage(kafka.FTMessage{Headers: map[string]string{"Origin-System-Id": testSystemID}, Body: `{"uuid":`})
+	service.HandleMessage(kafka.FTMessage{
+		Headers: map[string]string{"Origin-System-Id": testSystemID},
+		Body: fmt.Sprintf(`{"uuid":"%s","annotations":[{"predicate":"%s","id":"%s"}]}`,
+			uuid.NewString(), unsupportedPredicate, uuid.NewString()),
+	})
+
+	assert.Equal(t, consumed+3, testutil.ToFloat64(messagesConsumed), "consumed messages")
+	assert.Equal(t, skipped+1, testutil.ToFloat64(messagesSkipped.WithLabelValues(noAllowRuleMatched)), "skipped messages")
+	assert.Equal(t, unmarshalFailed+1, testutil.ToFloat64(unmarshalFailures), "unmarshal failures")
+	assert.Equal(t, unsupported+1, testutil.ToFloat64(unsupportedPredicates.WithLabelValues(unsupportedPredicate)), "unsupported predicates")
+	assert.Equal(t, produced+1, testutil.ToFloat64(messagesProduced.WithLabelValues(produceStatusSuccess)), "produced messages")
+	assert.Equal(t, handled+3, histogramSampleCount(t, handleDuration), "handling duration observations")
+}
+
+func histogramSampleCount(t *testing.T, h prometheus.Histogram) uint64 {
+	m := &dto.Metric{}
+	require.NoError(t, h.Write(m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestInvalidEventIsRejected(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	conceptID := uuid.NewString()
+
+	tests := map[string]struct {
+		body          string
+		expectedRules []string
+	}{
+		"missing uuid": {
+			body:          `{"annotations":[]}`,
+			expectedRules: []string{violationInvalidUUID},
+		},
+		"invalid uuid": {
+			body:          `{"uuid":"not-a-uuid","annotations":[]}`,
+			expectedRules: []string{violationInvalidUUID},
+		},
+		"non-URI predicate": {
+			body:          fmt.Sprintf(`{"uuid":"%s","annotations":[{"predicate":"about","id":"%s"}]}`, uuid.NewString(), conceptID),
+			expectedRules: []string{violationInvalidPredicate},
+		},
+		"empty concept id": {
+			body:          fmt.Sprintf(`{"uuid":"%s","annotations":[{"predicate":"http://www.ft.com/ontology/annotation/about","id":""}]}`, uuid.NewString()),
+			expectedRules: []string{violationInvalidConceptID},
+		},
+		"malformed concept id": {
+			body:          fmt.Sprintf(`{"uuid":"%s","annotations":[{"predicate":"http://www.ft.com/ontology/annotation/about","id":"bar"}]}`, uuid.NewString()),
+			expectedRules: []string{violationInvalidConceptID},
+		},
+		"several violations": {
+			body:          `{"uuid":"","annotations":[{"predicate":"","id":""}]}`,
+			expectedRules: []string{violationInvalidUUID, violationInvalidPredicate, violationInvalidConceptID},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			mp := &mockMessageProducer{}
+			dlp := &mockMessageProducer{}
+			dlp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+			service := NewAnnotationMapperService(whitelist, mp, log, WithDeadLetterProducer(dlp), WithSourceTopic("test-topic"))
+
+			counts := map[string]float64{}
+			for _, rule := range test.expectedRules {
+				counts[rule] = testutil.ToFloat64(invalidEvents.WithLabelValues(rule))
+			}
+
+			service.HandleMessage(kafka.FTMessage{
+				Headers: map[string]string{"Origin-System-Id": testSystemID},
+				Body:    test.body,
+			})
+
+			assert.Empty(t, mp.received, "invalid event should not be mapped")
+			require.Len(t, dlp.received, 1, "messages sent to dead-letter producer")
+			assert.Equal(t, deadLetterStageValidation, dlp.received[0].Headers["Dead-Letter-Stage"])
+
+			var actualRules []string
+			err := validateEvent(decodeEvent(t, test.body))
+			var validationErr *ValidationError
+			require.True(t, errors.As(err, &validationErr))
+			for _, v := range validationErr.Violations {
+				actualRules = append(actualRules, v.Rule)
+			}
+			assert.Equal(t, test.expectedRules, actualRules, "violated rules")
+
+			for _, rule := range test.expectedRules {
+				assert.Equal(t, counts[rule]+1, testutil.ToFloat64(invalidEvents.WithLabelValues(rule)), "invalid events for rule %s", rule)
+			}
+		})
+	}
+}
+
+func decodeEvent(t *testing.T, body string) PacMetadataPublishEvent {
+	var event PacMetadataPublishEvent
+	require.NoError(t, json.Unmarshal([]byte(body), &event))
+	return event
+}
+
+func TestDuplicateAnnotationsAreMappedOnce(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	mp := &mockMessageProducer{}
+	mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+	service := NewAnnotationMapperService(whitelist, mp, log)
+
+	conceptID := uuid.NewString()
+	duplicates := testutil.ToFloat64(duplicateAnnotations)
+	service.HandleMessage(kafka.FTMessage{
+		Headers: map[string]string{"Origin-System-Id": testSystemID},
+		Body: fmt.Sprintf(`{"uuid":"%s","annotations":[
+			{"predicate":"http://www.ft.com/ontology/annotation/about","id":"%s"},
+			{"predicate":"http://www.ft.com/ontology/annotation/about","id":"%s%s"}
+		]}`, uuid.NewString(), conceptID, thingURIPrefix, conceptID),
+	})
+
+	require.Len(t, mp.received, 1, "messages sent to producer")
+	actualBody := MappedAnnotations{}
+	require.NoError(t, json.Unmarshal([]byte(mp.received[0].Body), &actualBody))
+	assert.Len(t, actualBody.Annotations, 1, "duplicate annotation should be mapped once")
+	assert.Equal(t, duplicates+1, testutil.ToFloat64(duplicateAnnotations), "duplicate annotations")
+}
+
+func decodeMappedAnnotations(t *testing.T, msg kafka.FTMessage) MappedAnnotations {
+	var mapped MappedAnnotations
+	require.NoError(t, json.Unmarshal([]byte(msg.Body), &mapped))
+	return mapped
+}
+
+type blockingMessageProducer struct {
+	sending chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingMessageProducer) SendMessage(msg kafka.FTMessage) error {
+	return p.SendMessageWithContext(context.Background(), msg)
+}
+
+func (p *blockingMessageProducer) SendMessageWithContext(ctx context.Context, _ kafka.FTMessage) error {
+	close(p.sending)
+	select {
+	case <-p.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// slowMessageProducer is not context aware, so its sends cannot be interrupted.
+type slowMessageProducer struct {
+	mockMessageProducer
+	delay time.Duration
+}
+
+func (p *slowMessageProducer) SendMessage(msg kafka.FTMessage) error {
+	time.Sleep(p.delay)
+	return p.mockMessageProducer.SendMessage(msg)
+}
+
+func TestDrainWaitsForMessagesBeingHandled(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	mp := &blockingMessageProducer{sending: make(chan struct{}), release: make(chan struct{})}
+	service := NewAnnotationMapperService(whitelist, mp, log)
+
+	go service.HandleMessage(kafka.FTMessage{
+		Headers: map[string]string{"Origin-System-Id": testSystemID},
+		Body:    fmt.Sprintf(`{"uuid":"%s","annotations":[]}`, uuid.NewString()),
+	})
+	<-mp.sending
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, service.Drain(ctx), context.DeadlineExceeded, "drain should time out while a message is being sent")
+
+	close(mp.release)
+	assert.NoError(t, service.Drain(context.Background()), "drain should return once the message has been sent")
+}
+
+func TestMessageTimeoutIsSentToDeadLetter(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	mp := &blockingMessageProducer{sending: make(chan struct{}), release: make(chan struct{})}
+	defer close(mp.release)
+	dlp := &mockMessageProducer{}
+	dlp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+	service := NewAnnotationMapperService(whitelist, mp, log, WithDeadLetterProducer(dlp), WithSourceTopic("test-topic"), WithMessageTimeout(10*time.Millisecond))
+
+	timeouts := testutil.ToFloat64(messagesProduced.WithLabelValues(produceStatusTimeout))
+	service.HandleMessage(kafka.FTMessage{
+		Headers: map[string]string{"Origin-System-Id": testSystemID, "X-Request-Id": testTxID},
+		Body:    fmt.Sprintf(`{"uuid":"%s","annotations":[]}`, uuid.NewString()),
+	})
+
+	assert.Equal(t, timeouts+1, testutil.ToFloat64(messagesProduced.WithLabelValues(produceStatusTimeout)), "timed out messages")
+	require.Len(t, dlp.received, 1)
+	assert.Equal(t, deadLetterStageTimeout, dlp.received[0].Headers["Dead-Letter-Stage"])
+}
+
+func TestMessageTimeoutDoesNotInterruptProducersWithoutContext(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	mp := &slowMessageProducer{delay: 50 * time.Millisecond}
+	mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+	dlp := &mockMessageProducer{}
+	service := NewAnnotationMapperService(whitelist, mp, log, WithDeadLetterProducer(dlp), WithMessageTimeout(10*time.Millisecond))
+
+	successes := testutil.ToFloat64(messagesProduced.WithLabelValues(produceStatusSuccess))
+	service.HandleMessage(kafka.FTMessage{
+		Headers: map[string]string{"Origin-System-Id": testSystemID, "X-Request-Id": testTxID},
+		Body:    fmt.Sprintf(`{"uuid":"%s","annotations":[]}`, uuid.NewString()),
+	})
+
+	assert.Len(t, mp.received, 1, "the message should be written once the send completes")
+	assert.Equal(t, successes+1, testutil.ToFloat64(messagesProduced.WithLabelValues(produceStatusSuccess)), "sent messages")
+	assert.Empty(t, dlp.received, "a message which was written should not be dead-lettered")
+}
+
+func TestCancelledMessageIsSentToDeadLetter(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	mp := &blockingMessageProducer{sending: make(chan struct{}), release: make(chan struct{})}
+	defer close(mp.release)
+	dlp := &mockMessageProducer{}
+	dlp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+	service := NewAnnotationMapperService(whitelist, mp, log, WithDeadLetterProducer(dlp), WithSourceTopic("test-topic"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-mp.sending
+		cancel()
+	}()
+
+	cancelled := testutil.ToFloat64(messagesProduced.WithLabelValues(produceStatusCancel))
+	service.HandleMessageWithContext(ctx, kafka.FTMessage{
+		Headers: map[string]string{"Origin-System-Id": testSystemID, "X-Request-Id": testTxID},
+		Body:    fmt.Sprintf(`{"uuid":"%s","annotations":[]}`, uuid.NewString()),
+	})
+
+	assert.Equal(t, cancelled+1, testutil.ToFloat64(messagesProduced.WithLabelValues(produceStatusCancel)), "cancelled messages")
+	require.Len(t, dlp.received, 1, "cancelled messages should be dead-lettered")
+	assert.Equal(t, deadLetterStageCancelled, dlp.received[0].Headers["Dead-Letter-Stage"])
+}
+
+func TestStaleEventIsSkipped(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	tracker, err := NewTimestampTracker(10, nil)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		policy        StalePolicy
+		expectedSent  int
+		expectedStale string
+	}{
+		"off":  {policy: StalePolicyOff, expectedSent: 3},
+		"skip": {policy: StalePolicySkip, expectedSent: 2},
+		"flag": {policy: StalePolicyFlag, expectedSent: 3, expectedStale: "true"},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			mp := &mockMessageProducer{}
+			mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+			service := NewAnnotationMapperService(whitelist, mp, log, WithStaleEventDetection(test.policy, tracker))
+
+			contentUUID := uuid.NewString()
+			now := time.Now().UTC()
+			for _, timestamp := range []time.Time{now, now.Add(time.Second), now.Add(-time.Second)} {
+				service.HandleMessage(kafka.FTMessage{
+					Headers: map[string]string{
+						"Origin-System-Id":  testSystemID,
+						"X-Request-Id":      testTxID,
+						"Message-Timestamp": timestamp.Format(messageTimestampDateFormat),
+					},
+					Body: fmt.Sprintf(`{"uuid":"%s","annotations":[]}`, contentUUID),
+				})
+			}
+
+			require.Len(t, mp.received, test.expectedSent)
+			assert.Empty(t, mp.received[1].Headers[staleEventHeader], "a newer event should not be flagged")
+			assert.Equal(t, test.expectedStale, mp.received[len(mp.received)-1].Headers[staleEventHeader])
+		})
+	}
+}
+
+func TestMappedAnnotationsCarryLineageAndPassThroughHeaders(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	mp := &mockMessageProducer{}
+	mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+	service := NewAnnotationMapperService(whitelist, mp, log,
+		WithSourceTopic("test-topic"),
+		WithHeaderPassThrough([]string{"X-Custom", "Message-Type", "X-Missing"}),
+	)
+
+	messageID := uuid.NewString()
+	ctx := ContextWithMessageSource(context.Background(), MessageSource{Partition: 3, Offset: 42})
+	service.HandleMessageWithContext(ctx, kafka.FTMessage{
+		Headers: map[string]string{
+			"Origin-System-Id":  testSystemID,
+			"X-Request-Id":      testTxID,
+			"Message-Id":        messageID,
+			"Message-Type":      "cms-content-published",
+			"Message-Timestamp": "2022-06-01T10:00:00.000Z",
+			"X-Custom":          "custom value",
+		},
+		Body: fmt.Sprintf(`{"uuid":"%s","annotations":[]}`, uuid.NewString()),
+	})
+
+	require.Len(t, mp.received, 1)
+	headers := mp.received[0].Headers
+	assert.NotEqual(t, messageID, headers["Message-Id"], "the mapped message should have its own Message-Id")
+	assert.Equal(t, messageID, headers["Original-Message-Id"])
+	assert.Equal(t, "2022-06-01T10:00:00.000Z", headers["Original-Message-Timestamp"])
+	assert.Equal(t, "test-topic", headers["Source-Topic"])
+	assert.Equal(t, "3", headers["Source-Partition"])
+	assert.Equal(t, "42", headers["Source-Offset"])
+	assert.Equal(t, "custom value", headers["X-Custom"], "allow-listed headers should be passed through")
+	assert.Equal(t, "concept-annotation", headers["Message-Type"], "pass-through should not overwrite the mapper headers")
+	assert.NotContains(t, headers, "X-Missing")
+}
+
+func TestSourceOffsetIsUnknownWithoutMessageSource(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	mp := &mockMessageProducer{}
+	mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+	service := NewAnnotationMapperService(whitelist, mp, log, WithSourceTopic("test-topic"))
+
+	service.HandleMessage(kafka.FTMessage{
+		Headers: map[string]string{"Origin-System-Id": testSystemID, "X-Request-Id": testTxID},
+		Body:    fmt.Sprintf(`{"uuid":"%s","annotations":[]}`, uuid.NewString()),
+	})
+
+	require.Len(t, mp.received, 1)
+	headers := mp.received[0].Headers
+	assert.Equal(t, "test-topic", headers["Source-Topic"])
+	assert.NotContains(t, headers, "Source-Partition")
+	assert.NotContains(t, headers, "Source-Offset")
+	assert.NotContains(t, headers, "Original-Message-Id")
+}
+
+func TestTransactionIDIsGeneratedWhenMissing(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	mp := &mockMessageProducer{}
+	mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+	service := NewAnnotationMapperService(whitelist, mp, log)
+
+	inbound := kafka.FTMessage{
+		Headers: map[string]string{"Origin-System-Id": testSystemID},
+		Body:    fmt.Sprintf(`{"uuid":"%s","annotations":[]}`, uuid.NewString()),
+	}
+	service.HandleMessage(inbound)
+	service.HandleMessage(kafka.FTMessage{
+		Headers: map[string]string{"Origin-System-Id": testSystemID, "X-Request-Id": testTxID},
+		Body:    inbound.Body,
+	})
+
+	require.Len(t, mp.received, 2)
+	assert.Regexp(t, `^tid_[a-z0-9]{10}$`, mp.received[0].Headers["X-Request-Id"])
+	assert.Equal(t, "true", mp.received[0].Headers[generatedTransactionIDHeader])
+	assert.NotContains(t, inbound.Headers, "X-Request-Id", "inbound headers should not be modified")
+
+	assert.Equal(t, testTxID, mp.received[1].Headers["X-Request-Id"])
+	assert.NotContains(t, mp.received[1].Headers, generatedTransactionIDHeader)
+}
+
+func TestAnnotationDeltaIsEmitted(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	contentUUID := uuid.NewString()
+	conceptID := uuid.NewString()
+	about := fmt.Sprintf(`{"predicate":"http://www.ft.com/ontology/annotation/about","id":"%s"}`, conceptID)
+	mentions := fmt.Sprintf(`{"predicate":"http://www.ft.com/ontology/annotation/mentions","id":"%s"}`, conceptID)
+	bodies := []string{
+		fmt.Sprintf(`{"uuid":"%s","annotations":[%s]}`, contentUUID, about),
+		fmt.Sprintf(`{"uuid":"%s","annotations":[%s]}`, contentUUID, mentions),
+		fmt.Sprintf(`{"uuid":"%s","annotations":[%s]}`, contentUUID, mentions),
+	}
+
+	t.Run("topic", func(t *testing.T) {
+		history, err := NewAnnotationHistory(10)
+		require.NoError(t, err)
+		mp := &mockMessageProducer{}
+		mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+		dp := &mockMessageProducer{}
+		dp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+		service := NewAnnotationMapperService(whitelist, mp, log, WithAnnotationDiffing(DeltaModeTopic, history, dp))
+
+		for _, body := range bodies {
+			service.HandleMessage(kafka.FTMessage{Headers: map[string]string{"Origin-System-Id": testSystemID, "X-Request-Id": testTxID}, Body: body})
+		}
+
+		require.Len(t, mp.received, 3)
+		assert.NotContains(t, mp.received[1].Body, `"delta"`)
+		require.Len(t, dp.received, 2, "unchanged annotations should not emit a delta")
+		assert.Equal(t, deltaMessageType, dp.received[1].Headers["Message-Type"])
+		assert.Equal(t, testTxID, dp.received[1].Headers["X-Request-Id"])
+
+		var delta AnnotationsDelta
+		require.NoError(t, json.Unmarshal([]byte(dp.received[1].Body), &delta))
+		assert.Equal(t, contentUUID, delta.UUID)
+		assert.False(t, delta.Initial)
+		require.Len(t, delta.Added, 1)
+		assert.Equal(t, "mentions", delta.Added[0].Concept.Predicate)
+		require.Len(t, delta.Removed, 1)
+		assert.Equal(t, "about", delta.Removed[0].Concept.Predicate)
+	})
+
+	t.Run("field", func(t *testing.T) {
+		history, err := NewAnnotationHistory(10)
+		require.NoError(t, err)
+		mp := &mockMessageProducer{}
+		mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+		service := NewAnnotationMapperService(whitelist, mp, log, WithAnnotationDiffing(DeltaModeField, history, nil))
+
+		for _, body := range bodies[:2] {
+			service.HandleMessage(kafka.FTMessage{Headers: map[string]string{"Origin-System-Id": testSystemID, "X-Request-Id": testTxID}, Body: body})
+		}
+
+		require.Len(t, mp.received, 2)
+		mapped := decodeMappedAnnotations(t, mp.received[1])
+		require.NotNil(t, mapped.Delta)
+		require.Len(t, mapped.Delta.Added, 1)
+		assert.Equal(t, "mentions", mapped.Delta.Added[0].Concept.Predicate)
+		require.Len(t, mapped.Delta.Removed, 1)
+		assert.Equal(t, "about", mapped.Delta.Removed[0].Concept.Predicate)
+	})
+}
+
+func TestMappingReportIsPublished(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	ap := &mockMessageProducer{}
+	ap.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+	contentUUID := uuid.NewString()
+	conceptID := uuid.NewString()
+	about := fmt.Sprintf(`{"predicate":"http://www.ft.com/ontology/annotation/about","id":"%s"}`, conceptID)
+	body := fmt.Sprintf(`{"uuid":"%s","annotations":[%s,%s,{"predicate":"http://www.ft.com/ontology/unsupported","id":"%s"}]}`,
+		contentUUID, about, about, conceptID)
+
+	tests := map[string]struct {
+		producerErr    error
+		expectedStatus string
+	}{
+		"sent":   {expectedStatus: produceStatusSuccess},
+		"failed": {producerErr: errors.New("test error"), expectedStatus: produceStatusFailure},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			mp := &mockMessageProducer{}
+			mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(test.producerErr)
+			ap.received = nil
+			service := NewAnnotationMapperService(whitelist, mp, log, WithAuditProducer(ap))
+
+			service.HandleMessage(kafka.FTMessage{
+				Headers: map[string]string{"Origin-System-Id": testSystemID, "X-Request-Id": testTxID},
+				Body:    body,
+			})
+
+			require.Len(t, ap.received, 1)
+			assert.Equal(t, mappingReportMessageType, ap.received[0].Headers["Message-Type"])
+			assert.Equal(t, testTxID, ap.received[0].Headers["X-Request-Id"])
+
+			var report MappingReport
+			require.NoError(t, json.Unmarshal([]byte(ap.received[0].Body), &report))
+			assert.Equal(t, contentUUID, report.UUID)
+			assert.Equal(t, test.expectedStatus, report.Status)
+			assert.Equal(t, defaultRouteName, report.Route)
+			assert.Equal(t, 3, report.Received)
+			assert.Equal(t, 1, report.Mapped)
+			assert.Equal(t, 2, report.Dropped)
+			assert.Equal(t, 1, report.Deduplicated)
+			assert.Equal(t, 1, report.Normalised)
+			assert.Equal(t, map[string]int{dropReasonDuplicate: 1, dropReasonUnsupportedPredicate: 1}, report.DroppedByReason)
+			assert.Len(t, report.DroppedAnnotations, 2)
+		})
+	}
+}
+
+func TestMappingReportIsNotPublishedForFilteredMessages(t *testing.T) {
+	ap := &mockMessageProducer{}
+	ap.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	service := NewAnnotationMapperService(whitelist, &mockMessageProducer{}, logger.NewUnstructuredLogger(), WithAuditProducer(ap))
+
+	service.HandleMessage(kafka.FTMessage{
+		Headers: map[string]string{"Origin-System-Id": "http://cmdb.ft.com/systems/unknown", "X-Request-Id": testTxID},
+		Body:    fmt.Sprintf(`{"uuid":"%s","annotations":[]}`, uuid.NewString()),
+	})
+
+	assert.Empty(t, ap.received, "messages of other origin systems should not be reported")
+}
+
+func TestMappingReportIsPublishedForRejectedMessages(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+
+	tests := map[string]struct {
+		systemCode     string
+		body           string
+		expectedStatus string
+		expectedReason string
+		expectedUUID   string
+	}{
+		"not unmarshallable": {
+			systemCode:     testSystemID,
+			body:           `{"uuid":`,
+			expectedStatus: reportStatusRejected,
+			expectedReason: "unexpected end of JSON input",
+		},
+		"invalid": {
+			systemCode:     testSystemID,
+			body:           `{"uuid":"not-a-uuid","annotations":[]}`,
+			expectedStatus: reportStatusRejected,
+			expectedReason: `invalid metadata publish event: uuid "not-a-uuid" is not a valid UUID`,
+			expectedUUID:   "not-a-uuid",
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			ap := &mockMessageProducer{}
+			ap.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+			service := NewAnnotationMapperService(whitelist, &mockMessageProducer{}, log, WithAuditProducer(ap))
+
+			service.HandleMessage(kafka.FTMessage{
+				Headers: map[string]string{"Origin-System-Id": test.systemCode, "X-Request-Id": testTxID},
+				Body:    test.body,
+			})
+
+			require.Len(t, ap.received, 1, "every message passing the filter should be reported")
+			var report MappingReport
+			require.NoError(t, json.Unmarshal([]byte(ap.received[0].Body), &report))
+			assert.Equal(t, test.expectedStatus, report.Status)
+			assert.Equal(t, test.expectedReason, report.Reason)
+			assert.Equal(t, test.expectedUUID, report.UUID)
+			assert.Equal(t, testTxID, report.TransactionID)
+		})
+	}
+}
+
+func TestUnmappableEventPolicy(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	unmappable := fmt.Sprintf(`{"uuid":"%s","annotations":[{"predicate":"http://www.ft.com/ontology/unsupported","id":"%s"}]}`, uuid.NewString(), uuid.NewString())
+	empty := fmt.Sprintf(`{"uuid":"%s","annotations":[]}`, uuid.NewString())
+
+	tests := map[string]struct {
+		policy             UnmappablePolicy
+		body               string
+		expectedSent       int
+		expectedDeadLetter int
+	}{
+		"emit unmappable":           {policy: UnmappablePolicyEmit, body: unmappable, expectedSent: 1},
+		"skip unmappable":           {policy: UnmappablePolicySkip, body: unmappable},
+		"dead-letter unmappable":    {policy: UnmappablePolicyDeadLetter, body: unmappable, expectedDeadLetter: 1},
+		"skip genuine empty":        {policy: UnmappablePolicySkip, body: empty, expectedSent: 1},
+		"dead-letter genuine empty": {policy: UnmappablePolicyDeadLetter, body: empty, expectedSent: 1},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			mp := &mockMessageProducer{}
+			mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+			dlp := &mockMessageProducer{}
+			dlp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+			service := NewAnnotationMapperService(whitelist, mp, log, WithDeadLetterProducer(dlp), WithUnmappablePolicy(test.policy))
+
+			service.HandleMessage(kafka.FTMessage{
+				Headers: map[string]string{"Origin-System-Id": testSystemID, "X-Request-Id": testTxID},
+				Body:    test.body,
+			})
+
+			assert.Len(t, mp.received, test.expectedSent, "mapped annotations messages")
+			require.Len(t, dlp.received, test.expectedDeadLetter, "dead-letter messages")
+			if test.expectedDeadLetter > 0 {
+				assert.Equal(t, deadLetterStageUnmappable, dlp.received[0].Headers["Dead-Letter-Stage"])
+			}
+		})
+	}
+}
+
+func TestMessageMappedWithProvenance(t *testing.T) {
+	tests := map[string]struct {
+		provenance string
+		expected   *Provenance
+	}{
+		"all fields": {
+			provenance: `"submittedBy":"test-user","submittedAt":"2022-06-01T10:00:00.000Z","lifecycle":"annotations-pac",`,
+			expected:   &Provenance{SubmittedBy: "test-user", SubmittedAt: "2022-06-01T10:00:00.000Z", Lifecycle: "annotations-pac"},
+		},
+		"submitter only": {
+			provenance: `"submittedBy":"test-user",`,
+			expected:   &Provenance{SubmittedBy: "test-user"},
+		},
+		"no provenance": {},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			mp := &mockMessageProducer{}
+			mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+			service := NewAnnotationMapperService(regexp.MustCompile(regexp.QuoteMeta(testSystemID)), mp, logger.NewUnstructuredLogger())
+
+			service.HandleMessage(kafka.FTMessage{
+				Headers: map[string]string{"Origin-System-Id": testSystemID, "X-Request-Id": testTxID},
+				Body:    fmt.Sprintf(`{"uuid":"%s",%s"annotations":[]}`, uuid.NewString(), test.provenance),
+			})
+
+			require.Len(t, mp.received, 1, "messages sent to producer")
+			assert.Equal(t, test.expected, decodeMappedAnnotations(t, mp.received[0]).Provenance)
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package service
+
+import "context"
+
+type messageSourceKey struct{}
+
+// MessageSource is where a consumed message was read from. The partition and offset are only known
+// when the consumer exposes them, e.g. when replaying.
+type MessageSource struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+}
+
+// ContextWithMessageSource returns a copy of the context carrying the source of the message being handled.
+func ContextWithMessageSource(ctx context.Context, source MessageSource) context.Context {
+	return context.WithValue(ctx, messageSourceKey{}, source)
+}
+
+func messageSourceFrom(ctx context.Context) (MessageSource, bool) {
+	source, found := ctx.Value(messageSourceKey{}).(MessageSource)
+	return source, found
+}
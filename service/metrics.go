@@ -0,0 +1,94 @@
+package service
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "pac_annotations_mapper"
+
+const (
+	produceStatusSuccess = "success"
+	produceStatusFailure = "failure"
+	produceStatusTimeout = "timeout"
+	produceStatusCancel  = "cancelled"
+)
+
+var (
+	messagesConsumed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_consumed_total",
+		Help:      "Number of messages consumed from the metadata topic.",
+	})
+	messagesSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_skipped_total",
+		Help:      "Number of messages skipped by their headers, by the deny or require rule which skipped them or no-allow-rule.",
+	}, []string{"rule"})
+	unmarshalFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "unmarshal_failures_total",
+		Help:      "Number of messages whose body could not be unmarshalled.",
+	})
+	invalidEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "invalid_events_total",
+		Help:      "Number of events rejected by validation, by violated rule. An event violating several rules is counted once per rule.",
+	}, []string{"rule"})
+	unsupportedPredicates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "unsupported_predicates_total",
+		Help:      "Number of annotations not mapped because of an unsupported predicate.",
+	}, []string{"predicate"})
+	duplicateAnnotations = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "duplicate_annotations_total",
+		Help:      "Number of annotations not mapped because the same concept and predicate pair was already mapped for the event.",
+	})
+	conflictingAnnotations = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "conflicting_annotations_total",
+		Help:      "Number of annotations not mapped because of a conflicting predicate for the same concept.",
+	})
+	conceptIDsNormalised = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "concept_ids_normalised_total",
+		Help:      "Number of concept ids rewritten to the canonical thing URI.",
+	})
+	unmappableEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "unmappable_events_total",
+		Help:      "Number of events with annotations none of which could be mapped, by action taken.",
+	}, []string{"action"})
+	staleEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "stale_events_total",
+		Help:      "Number of events older than the last event emitted for the same content, by action taken.",
+	}, []string{"action"})
+	deleteEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "delete_events_total",
+		Help:      "Number of delete events asking for all the PAC annotations of the content to be removed.",
+	})
+	messagesProduced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_produced_total",
+		Help:      "Number of attempts to write mapped annotations to the queue, by status.",
+	}, []string{"status"})
+	deltasProduced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "deltas_produced_total",
+		Help:      "Number of attempts to write annotation deltas to the delta topic, by status.",
+	}, []string{"status"})
+	reportsProduced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reports_produced_total",
+		Help:      "Number of attempts to write mapping reports to the audit topic, by status.",
+	}, []string{"status"})
+	handleDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "handle_duration_seconds",
+		Help:      "Time taken to handle a consumed message, from receipt until the mapped annotations are written.",
+		Buckets:   prometheus.DefBuckets,
+	})
+)
@@ -4,6 +4,12 @@ package service
 type PacMetadataPublishEvent struct {
 	UUID        string                  `json:"uuid"`
 	Annotations []PacMetadataAnnotation `json:"annotations"`
+	// Deleted asks for all the PAC annotations of the content to be removed. The event must have no annotations.
+	Deleted bool `json:"deleted"`
+	// SubmittedBy, SubmittedAt and Lifecycle describe who curated the annotations in PAC, when and at which stage
+	SubmittedBy string `json:"submittedBy"`
+	SubmittedAt string `json:"submittedAt"`
+	Lifecycle   string `json:"lifecycle"`
 }
 
 type PacMetadataAnnotation struct {
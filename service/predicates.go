@@ -0,0 +1,178 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultPredicates is used when no predicate mapping file is configured.
+var defaultPredicates = map[string]string{
+	"http://www.ft.com/ontology/hasBrand":                      "hasBrand",
+	"http://www.ft.com/ontology/classification/isClassifiedBy": "isClassifiedBy",
+	"http://www.ft.com/ontology/implicitlyClassifiedBy":        "implicitlyClassifiedBy",
+	"http://www.ft.com/ontology/annotation/hasAuthor":          "hasAuthor",
+	"http://www.ft.com/ontology/hasContributor":                "hasContributor",
+	"http://www.ft.com/ontology/annotation/about":              "about",
+	"http://www.ft.com/ontology/hasDisplayTag":                 "hasDisplayTag",
+	"http://www.ft.com/ontology/annotation/mentions":           "mentions",
+}
+
+var uppPredicateRegex = regexp.MustCompile(`^[a-zA-Z]+$`)
+
+// predicateMappingFile is the format of the predicate mapping configuration. Both YAML and JSON are accepted.
+type predicateMappingFile struct {
+	Predicates map[string]string `yaml:"predicates"`
+}
+
+// PredicateMapping maps PAC predicate URIs to UPP predicates.
+// It is safe for concurrent use, so the mapping can be reloaded while messages are being handled.
+type PredicateMapping struct {
+	path       string
+	lock       *sync.RWMutex
+	predicates map[string]string
+	modTime    time.Time
+	log        *logger.UPPLogger
+}
+
+// DefaultPredicateMapping returns the built-in mapping, which can't be reloaded.
+func DefaultPredicateMapping() *PredicateMapping {
+	return &PredicateMapping{
+		lock:       &sync.RWMutex{},
+		predicates: defaultPredicates,
+	}
+}
+
+// LoadPredicateMapping reads and validates the mapping from the given file.
+func LoadPredicateMapping(path string, log *logger.UPPLogger) (*PredicateMapping, error) {
+	m := &PredicateMapping{
+		path: path,
+		lock: &sync.RWMutex{},
+		log:  log,
+	}
+
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Lookup returns the UPP predicate for the given PAC predicate URI.
+func (m *PredicateMapping) Lookup(predicateURI string) (string, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	predicate, found := m.predicates[predicateURI]
+	return predicate, found
+}
+
+// Path returns the file the mapping is loaded from, which is empty for the built-in mapping.
+func (m *PredicateMapping) Path() string {
+	return m.path
+}
+
+// Reload reads the mapping file again. The current mapping is kept if the file can't be read or is invalid.
+func (m *PredicateMapping) Reload() error {
+	if m.path == "" {
+		return nil
+	}
+
+	info, err := os.Stat(m.path)
+	if err != nil {
+		return fmt.Errorf("cannot read predicate mapping file: %w", err)
+	}
+
+	predicates, err := readPredicateMappingFile(m.path)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.predicates = predicates
+	m.modTime = info.ModTime()
+
+	return nil
+}
+
+// Watch reloads the mapping whenever the file modification time changes, checking on the given interval until stop is closed.
+func (m *PredicateMapping) Watch(interval time.Duration, stop <-chan struct{}) {
+	if m.path == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(m.path)
+			if err != nil {
+				m.log.WithError(err).WithField("path", m.path).Error("Cannot check predicate mapping file for changes")
+				continue
+			}
+
+			if !m.changedSince(info.ModTime()) {
+				continue
+			}
+
+			if err = m.Reload(); err != nil {
+				m.log.WithError(err).WithField("path", m.path).Error("Keeping the current predicate mapping as the changed file is invalid")
+				continue
+			}
+			m.log.WithField("path", m.path).Info("Reloaded predicate mapping")
+		}
+	}
+}
+
+func (m *PredicateMapping) changedSince(modTime time.Time) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return !modTime.Equal(m.modTime)
+}
+
+func readPredicateMappingFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read predicate mapping file: %w", err)
+	}
+
+	var config predicateMappingFile
+	if err = yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("cannot parse predicate mapping file: %w", err)
+	}
+
+	if err = validatePredicates(config.Predicates); err != nil {
+		return nil, err
+	}
+
+	return config.Predicates, nil
+}
+
+func validatePredicates(predicates map[string]string) error {
+	if len(predicates) == 0 {
+		return fmt.Errorf("predicate mapping is empty")
+	}
+
+	for pacPredicate, uppPredicate := range predicates {
+		if !isAbsoluteURI(pacPredicate) {
+			return fmt.Errorf("PAC predicate %q is not an absolute URI", pacPredicate)
+		}
+		if !uppPredicateRegex.MatchString(uppPredicate) {
+			return fmt.Errorf("UPP predicate %q for %q is invalid", uppPredicate, pacPredicate)
+		}
+	}
+
+	return nil
+}
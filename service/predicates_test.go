@@ -0,0 +1,105 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, path string, content string) {
+	err := os.WriteFile(path, []byte(content), 0600)
+	require.NoError(t, err)
+}
+
+func TestLoadPredicateMapping(t *testing.T) {
+	tests := map[string]struct {
+		content     string
+		expectError bool
+	}{
+		"yaml": {
+			content: "predicates:\n  http://www.ft.com/ontology/annotation/about: about\n",
+		},
+		"json": {
+			content: `{"predicates":{"http://www.ft.com/ontology/annotation/about":"about"}}`,
+		},
+		"empty": {
+			content:     "predicates: {}\n",
+			expectError: true,
+		},
+		"relative predicate URI": {
+			content:     "predicates:\n  about: about\n",
+			expectError: true,
+		},
+		"invalid UPP predicate": {
+			content:     "predicates:\n  http://www.ft.com/ontology/annotation/about: \"is about\"\n",
+			expectError: true,
+		},
+		"malformed": {
+			content:     "predicates: [",
+			expectError: true,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "predicates.yaml")
+			writeConfigFile(t, path, test.content)
+
+			m, err := LoadPredicateMapping(path, logger.NewUnstructuredLogger())
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			predicate, found := m.Lookup("http://www.ft.com/ontology/annotation/about")
+			assert.True(t, found)
+			assert.Equal(t, "about", predicate)
+		})
+	}
+}
+
+func TestPredicateMappingReloadKeepsCurrentMappingOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "predicates.yaml")
+	writeConfigFile(t, path, "predicates:\n  http://www.ft.com/ontology/annotation/about: about\n")
+
+	m, err := LoadPredicateMapping(path, logger.NewUnstructuredLogger())
+	require.NoError(t, err)
+
+	writeConfigFile(t, path, "predicates:\n  http://www.ft.com/ontology/annotation/mentions: mentions\n")
+	require.NoError(t, m.Reload())
+	_, found := m.Lookup("http://www.ft.com/ontology/annotation/about")
+	assert.False(t, found, "predicate removed from the file should no longer be mapped")
+	_, found = m.Lookup("http://www.ft.com/ontology/annotation/mentions")
+	assert.True(t, found, "predicate added to the file should be mapped")
+
+	writeConfigFile(t, path, "predicates: {}\n")
+	assert.Error(t, m.Reload())
+	_, found = m.Lookup("http://www.ft.com/ontology/annotation/mentions")
+	assert.True(t, found, "current mapping should be kept")
+}
+
+func TestPredicateMappingWatchReloadsChangedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "predicates.yaml")
+	writeConfigFile(t, path, "predicates:\n  http://www.ft.com/ontology/annotation/about: about\n")
+
+	m, err := LoadPredicateMapping(path, logger.NewUnstructuredLogger())
+	require.NoError(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go m.Watch(10*time.Millisecond, stop)
+
+	writeConfigFile(t, path, "predicates:\n  http://www.ft.com/ontology/annotation/about: mentions\n")
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+
+	assert.Eventually(t, func() bool {
+		predicate, _ := m.Lookup("http://www.ft.com/ontology/annotation/about")
+		return predicate == "mentions"
+	}, time.Second, 10*time.Millisecond)
+}
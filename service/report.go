@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/Financial-Times/kafka-client-go/v3"
+	"github.com/google/uuid"
+)
+
+const (
+	mappingReportEvent       = "MappingReport"
+	mappingReportMessageType = "concept-annotation-mapping-report"
+
+	reportStatusSkipped      = "skipped"
+	reportStatusDeadLettered = "deadLettered"
+	reportStatusRejected     = "rejected"
+)
+
+// reportLogMessages are the log messages of the reports by status. Other statuses failed to be sent.
+var reportLogMessages = map[string]string{
+	produceStatusSuccess:     "Mapped metadata publish event",
+	reportStatusSkipped:      "Skipped metadata publish event",
+	reportStatusRejected:     "Rejected metadata publish event",
+	reportStatusDeadLettered: "Dead-lettered metadata publish event",
+}
+
+// MappingReport summarises how the annotations of a single event were mapped and whether they were sent.
+type MappingReport struct {
+	UUID           string `json:"uuid"`
+	TransactionID  string `json:"transactionId"`
+	OriginSystemID string `json:"originSystemId"`
+	Route          string `json:"route"`
+	Status         string `json:"status"`
+	// Reason explains why an event was skipped or rejected before being mapped
+	Reason             string              `json:"reason,omitempty"`
+	Deleted            bool                `json:"deleted"`
+	Provenance         *Provenance         `json:"provenance,omitempty"`
+	Received           int                 `json:"received"`
+	Mapped             int                 `json:"mapped"`
+	Dropped            int                 `json:"dropped"`
+	DroppedByReason    map[string]int      `json:"droppedByReason"`
+	Deduplicated       int                 `json:"deduplicated"`
+	Normalised         int                 `json:"normalised"`
+	DroppedAnnotations []DroppedAnnotation `json:"droppedAnnotations"`
+	Timestamp          string              `json:"timestamp"`
+}
+
+// newMappingReport starts the report of a consumed message, with the failure status until it is known to be sent.
+func newMappingReport(tid string, systemCode string) *MappingReport {
+	return &MappingReport{
+		TransactionID:      tid,
+		OriginSystemID:     systemCode,
+		Status:             produceStatusFailure,
+		DroppedByReason:    map[string]int{},
+		DroppedAnnotations: []DroppedAnnotation{},
+	}
+}
+
+// skipped marks the event as skipped before being sent for the given reason.
+func (report *MappingReport) skipped(reason string) {
+	report.Status = reportStatusSkipped
+	report.Reason = reason
+}
+
+// rejected marks the message as rejected before being mapped because of the error.
+func (report *MappingReport) rejected(err error) {
+	report.Status = reportStatusRejected
+	report.Reason = err.Error()
+}
+
+// addMapping records how the annotations of the event were mapped.
+func (report *MappingReport) addMapping(event PacMetadataPublishEvent, result MappingResult) {
+	report.Received = len(event.Annotations)
+	report.Mapped = len(result.Annotations)
+	report.Dropped = len(result.Dropped)
+	report.Normalised = len(result.Normalised)
+	report.DroppedAnnotations = result.Dropped
+	report.Provenance = result.Provenance
+	for _, dropped := range result.Dropped {
+		report.DroppedByReason[dropped.Reason]++
+	}
+	report.Deduplicated = report.DroppedByReason[dropReasonDuplicate]
+}
+
+// reportMapping logs the report as a single monitoring event and publishes it to the audit topic, if configured.
+func (mapper *AnnotationMapperService) reportMapping(report *MappingReport) {
+	report.Timestamp = time.Now().Format(messageTimestampDateFormat)
+
+	message, found := reportLogMessages[report.Status]
+	if !found {
+		message = "Failed to send mapped metadata publish event"
+	}
+
+	mapper.log.WithMonitoringEvent(mappingReportEvent, report.TransactionID, annotationsType).
+		WithUUID(report.UUID).
+		WithValidFlag(report.Status == produceStatusSuccess).
+		WithFields(map[string]interface{}{
+			"route":               report.Route,
+			"status":              report.Status,
+			"reason":              report.Reason,
+			"deleted":             report.Deleted,
+			"provenance":          report.Provenance,
+			"received":            report.Received,
+			"mapped":              report.Mapped,
+			"dropped":             report.Dropped,
+			"dropped_by_reason":   report.DroppedByReason,
+			"deduplicated":        report.Deduplicated,
+			"normalised":          report.Normalised,
+			"dropped_annotations": report.DroppedAnnotations,
+		}).
+		Info(message)
+
+	if mapper.auditProducer == nil {
+		return
+	}
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		mapper.log.WithTransactionID(report.TransactionID).WithError(err).Error("Error marshalling the mapping report")
+		return
+	}
+
+	// the report of a message which timed out or was cancelled is still published
+	ctx := context.Background()
+	if mapper.messageTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, mapper.messageTimeout)
+		defer cancel()
+	}
+
+	err = sendMessage(ctx, mapper.auditProducer, kafka.FTMessage{
+		Headers: map[string]string{
+			"Message-Id":        uuid.NewString(),
+			"Message-Type":      mappingReportMessageType,
+			"Content-Type":      "application/json",
+			"X-Request-Id":      report.TransactionID,
+			"Origin-System-Id":  report.OriginSystemID,
+			"Message-Timestamp": report.Timestamp,
+		},
+		Body: string(body),
+	})
+	if err != nil {
+		mapper.log.WithTransactionID(report.TransactionID).
+			WithUUID(report.UUID).
+			WithError(err).
+			Error("Error sending mapping report to the audit queue")
+		reportsProduced.WithLabelValues(produceStatusFailure).Inc()
+		return
+	}
+	reportsProduced.WithLabelValues(produceStatusSuccess).Inc()
+}
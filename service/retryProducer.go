@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/v3"
+)
+
+// RetryPolicy describes how many times a message is sent before giving up and how long to wait between attempts.
+// The wait doubles after every failed attempt, starting at InitialBackoff and capped at MaxBackoff,
+// and is randomised between half and the full value to avoid retrying in lockstep.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// RetryingProducer wraps a kafka producer, retrying failed sends according to a RetryPolicy.
+type RetryingProducer struct {
+	producer kafkaProducer
+	policy   RetryPolicy
+	log      *logger.UPPLogger
+	wait     func(ctx context.Context, d time.Duration) error
+}
+
+func NewRetryingProducer(producer kafkaProducer, policy RetryPolicy, log *logger.UPPLogger) *RetryingProducer {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.MaxBackoff < policy.InitialBackoff {
+		policy.MaxBackoff = policy.InitialBackoff
+	}
+
+	return &RetryingProducer{
+		producer: producer,
+		policy:   policy,
+		log:      log,
+		wait:     waitWithContext,
+	}
+}
+
+// SendMessage sends the message, retrying until it succeeds or the maximum number of attempts is reached.
+// The error of the last attempt is returned.
+func (p *RetryingProducer) SendMessage(message kafka.FTMessage) error {
+	return p.SendMessageWithContext(context.Background(), message)
+}
+
+// SendMessageWithContext is like SendMessage, but stops retrying and returns the context error once the context is done.
+func (p *RetryingProducer) SendMessageWithContext(ctx context.Context, message kafka.FTMessage) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = sendMessage(ctx, p.producer, message)
+		if err == nil || ctx.Err() != nil || attempt >= p.policy.MaxAttempts {
+			return err
+		}
+
+		backoff := p.policy.backoffFor(attempt)
+		p.log.WithMonitoringEvent(mapperEvent, message.Headers["X-Request-Id"], annotationsType).
+			WithValidFlag(true).
+			WithError(err).
+			WithField("attempt", attempt).
+			WithField("backoff", backoff.String()).
+			Warn("Error sending message to queue, retrying")
+		if waitErr := p.wait(ctx, backoff); waitErr != nil {
+			return waitErr
+		}
+	}
+}
+
+func waitWithContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoffFor returns the jittered wait after the given failed attempt.
+func (p *RetryPolicy) backoffFor(attempt int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempt && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(backoff-half)+1))
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRetryingProducerSucceedsAfterRetries(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	mp := &mockMessageProducer{}
+	mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(errors.New("test error")).Twice()
+	mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil).Once()
+
+	var waits []time.Duration
+	p := NewRetryingProducer(mp, RetryPolicy{MaxAttempts: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}, log)
+	p.wait = func(_ context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+
+	err := p.SendMessage(kafka.FTMessage{Headers: map[string]string{"X-Request-Id": testTxID}})
+	assert.NoError(t, err)
+	assert.Len(t, mp.received, 3, "send attempts")
+	assert.Len(t, waits, 2, "backoff waits")
+	mp.AssertExpectations(t)
+}
+
+func TestRetryingProducerGivesUpAfterMaxAttempts(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	errmsg := errors.New("test error")
+	mp := &mockMessageProducer{}
+	mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(errmsg)
+
+	p := NewRetryingProducer(mp, RetryPolicy{MaxAttempts: 3}, log)
+	p.wait = func(context.Context, time.Duration) error { return nil }
+
+	err := p.SendMessage(kafka.FTMessage{})
+	assert.Equal(t, errmsg, err)
+	assert.Len(t, mp.received, 3, "send attempts")
+}
+
+func TestRetryPolicyBackoffIsExponentialAndCapped(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 500 * time.Millisecond}
+
+	tests := map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 400 * time.Millisecond,
+		4: 500 * time.Millisecond,
+		8: 500 * time.Millisecond,
+	}
+
+	for attempt, expected := range tests {
+		for i := 0; i < 20; i++ {
+			actual := policy.backoffFor(attempt)
+			assert.GreaterOrEqual(t, actual, expected/2, "backoff after attempt %d", attempt)
+			assert.LessOrEqual(t, actual, expected, "backoff after attempt %d", attempt)
+		}
+	}
+}
+
+func TestRetryingProducerStopsWhenContextIsDone(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	mp := &mockMessageProducer{}
+	mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(errors.New("test error"))
+
+	p := NewRetryingProducer(mp, RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Minute, MaxBackoff: time.Minute}, log)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := p.SendMessageWithContext(ctx, kafka.FTMessage{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Len(t, mp.received, 1, "send attempts")
+}
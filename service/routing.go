@@ -0,0 +1,118 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultRouteName = "default"
+
+// RouteConfig is a single entry of the routing configuration file.
+type RouteConfig struct {
+	Name                  string `yaml:"name"`
+	OriginSystemIDPattern string `yaml:"originSystemIdPattern"`
+	Topic                 string `yaml:"topic"`
+	// PredicatesConfig is an optional predicate mapping file for the route. The service mapping is used if empty.
+	PredicatesConfig string `yaml:"predicatesConfig"`
+}
+
+type routingFile struct {
+	Routes []RouteConfig `yaml:"routes"`
+}
+
+// Route sends the annotations of messages whose Origin-System-Id matches the pattern to a dedicated producer,
+// optionally mapping them with a dedicated predicate mapping.
+type Route struct {
+	Name           string
+	OriginSystemID *regexp.Regexp
+	Producer       kafkaProducer
+	Predicates     *PredicateMapping
+}
+
+// LoadRoutingConfig reads and validates the routing configuration from the given YAML or JSON file.
+func LoadRoutingConfig(path string) ([]RouteConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read routing config file: %w", err)
+	}
+
+	var config routingFile
+	if err = yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("cannot parse routing config file: %w", err)
+	}
+
+	if len(config.Routes) == 0 {
+		return nil, fmt.Errorf("routing config has no routes")
+	}
+
+	names := map[string]bool{}
+	for i, route := range config.Routes {
+		if route.Name == "" {
+			return nil, fmt.Errorf("route %d has no name", i)
+		}
+		if names[route.Name] {
+			return nil, fmt.Errorf("route name %q is used more than once", route.Name)
+		}
+		names[route.Name] = true
+
+		if route.Topic == "" {
+			return nil, fmt.Errorf("route %q has no topic", route.Name)
+		}
+		if _, err = regexp.Compile(route.OriginSystemIDPattern); err != nil || route.OriginSystemIDPattern == "" {
+			return nil, fmt.Errorf("route %q has an invalid originSystemIdPattern %q", route.Name, route.OriginSystemIDPattern)
+		}
+	}
+
+	return config.Routes, nil
+}
+
+// route is the destination and predicate mapping chosen for a message.
+type route struct {
+	name       string
+	producer   kafkaProducer
+	predicates *PredicateMapping
+}
+
+// routeFor applies the filter and the routes to the message headers. Messages matching a deny rule or failing
+// a require rule are skipped, then the first route matching the Origin-System-Id is returned, falling back to the
+// service producer and predicate mapping if the Origin-System-Id matches an allow rule. It returns nil if the message
+// should be skipped, along with the name of the deny or require rule which skipped it or noAllowRuleMatched.
+// Otherwise the name of the matching route or allow rule is returned.
+func (mapper *AnnotationMapperService) routeFor(headers map[string]string) (*route, string) {
+	if rule, rejected := mapper.filter.rejected(headers); rejected {
+		return nil, rule
+	}
+	return mapper.matchRoute(headers[originSystemIDHeader])
+}
+
+// routeForOrigin is routeFor applying only the filter rules on the Origin-System-Id, for requests whose other headers
+// are not those of the messages.
+func (mapper *AnnotationMapperService) routeForOrigin(systemCode string) (*route, string) {
+	headers := map[string]string{originSystemIDHeader: systemCode}
+	if rule, rejected := mapper.filter.originRules().rejected(headers); rejected {
+		return nil, rule
+	}
+	return mapper.matchRoute(systemCode)
+}
+
+// matchRoute returns the first route or allow rule matching the Origin-System-Id.
+func (mapper *AnnotationMapperService) matchRoute(systemCode string) (*route, string) {
+	for _, r := range mapper.routes {
+		if r.OriginSystemID.MatchString(systemCode) {
+			predicates := r.Predicates
+			if predicates == nil {
+				predicates = mapper.predicates
+			}
+			return &route{name: r.Name, producer: r.Producer, predicates: predicates}, r.Name
+		}
+	}
+
+	if rule, allowed := mapper.filter.allowed(systemCode); allowed {
+		return &route{name: defaultRouteName, producer: mapper.messageProducer, predicates: mapper.predicates}, rule
+	}
+
+	return nil, noAllowRuleMatched
+}
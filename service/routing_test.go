@@ -0,0 +1,153 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/v3"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRoutingConfig(t *testing.T) {
+	tests := map[string]struct {
+		content     string
+		expectError bool
+	}{
+		"valid": {
+			content: `
+routes:
+  - name: pac
+    originSystemIdPattern: http://cmdb\.ft\.com/systems/pac
+    topic: ConceptAnnotations
+  - name: other
+    originSystemIdPattern: http://cmdb\.ft\.com/systems/other
+    topic: OtherAnnotations
+    predicatesConfig: /config/other-predicates.yaml
+`,
+		},
+		"no routes": {
+			content:     "routes: []",
+			expectError: true,
+		},
+		"missing name": {
+			content:     "routes:\n  - originSystemIdPattern: pac\n    topic: ConceptAnnotations\n",
+			expectError: true,
+		},
+		"duplicate name": {
+			content:     "routes:\n  - name: pac\n    originSystemIdPattern: pac\n    topic: A\n  - name: pac\n    originSystemIdPattern: pac\n    topic: B\n",
+			expectError: true,
+		},
+		"missing topic": {
+			content:     "routes:\n  - name: pac\n    originSystemIdPattern: pac\n",
+			expectError: true,
+		},
+		"invalid pattern": {
+			content:     "routes:\n  - name: pac\n    originSystemIdPattern: \"pac(\"\n    topic: A\n",
+			expectError: true,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "routing.yaml")
+			writeConfigFile(t, path, test.content)
+
+			routes, err := LoadRoutingConfig(path)
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, routes, 2)
+			assert.Equal(t, "OtherAnnotations", routes[1].Topic)
+			assert.Equal(t, "/config/other-predicates.yaml", routes[1].PredicatesConfig)
+		})
+	}
+}
+
+func TestMessagesAreRoutedByOriginSystemID(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	const otherSystemID = "http://cmdb.ft.com/systems/other"
+
+	predicatesPath := filepath.Join(t.TempDir(), "predicates.yaml")
+	writeConfigFile(t, predicatesPath, "predicates:\n  http://www.ft.com/ontology/other/about: about\n")
+	otherPredicates, err := LoadPredicateMapping(predicatesPath, log)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		systemID          string
+		predicate         string
+		expectDefault     bool
+		expectRouted      bool
+		expectAnnotations int
+	}{
+		"whitelisted origin uses the service producer": {
+			systemID:          testSystemID,
+			predicate:         "http://www.ft.com/ontology/annotation/about",
+			expectDefault:     true,
+			expectAnnotations: 1,
+		},
+		"routed origin uses the route producer and predicates": {
+			systemID:          otherSystemID,
+			predicate:         "http://www.ft.com/ontology/other/about",
+			expectRouted:      true,
+			expectAnnotations: 1,
+		},
+		"routed origin does not use the service predicates": {
+			systemID:     otherSystemID,
+			predicate:    "http://www.ft.com/ontology/annotation/about",
+			expectRouted: true,
+		},
+		"unknown origin is skipped": {
+			systemID: "http://cmdb.ft.com/systems/unknown",
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			mp := &mockMessageProducer{}
+			mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+			rp := &mockMessageProducer{}
+			rp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+			service := NewAnnotationMapperService(whitelist, mp, log, WithRoutes([]Route{{
+				Name:           "other",
+				OriginSystemID: regexp.MustCompile(`http://cmdb\.ft\.com/systems/other`),
+				Producer:       rp,
+				Predicates:     otherPredicates,
+			}}))
+
+			service.HandleMessage(kafka.FTMessage{
+				Headers: map[string]string{"Origin-System-Id": test.systemID},
+				Body: fmt.Sprintf(`{"uuid":"%s","annotations":[{"predicate":"%s","id":"%s"}]}`,
+					uuid.NewString(), test.predicate, uuid.NewString()),
+			})
+
+			received := append(mp.received, rp.received...)
+			if test.expectDefault {
+				assert.Len(t, mp.received, 1, "messages sent to the service producer")
+			} else {
+				assert.Empty(t, mp.received, "messages sent to the service producer")
+			}
+			if test.expectRouted {
+				assert.Len(t, rp.received, 1, "messages sent to the route producer")
+			} else {
+				assert.Empty(t, rp.received, "messages sent to the route producer")
+			}
+			if len(received) == 0 {
+				return
+			}
+
+			assert.Len(t, decodeMappedAnnotations(t, received[0]).Annotations, test.expectAnnotations)
+		})
+	}
+}
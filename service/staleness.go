@@ -0,0 +1,239 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const staleEventHeader = "Stale-Event"
+
+// StalePolicy decides what happens to an event which is older than the last event emitted for the same content.
+type StalePolicy string
+
+const (
+	// StalePolicyOff maps every event regardless of its timestamp.
+	StalePolicyOff StalePolicy = "off"
+	// StalePolicySkip does not emit stale events.
+	StalePolicySkip StalePolicy = "skip"
+	// StalePolicyFlag emits stale events with the Stale-Event header set to true.
+	StalePolicyFlag StalePolicy = "flag"
+)
+
+func ParseStalePolicy(s string) (StalePolicy, error) {
+	switch p := StalePolicy(s); p {
+	case StalePolicyOff, StalePolicySkip, StalePolicyFlag:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown stale event policy %q, expected one of %s, %s or %s", s, StalePolicyOff, StalePolicySkip, StalePolicyFlag)
+}
+
+// TimestampStore persists the timestamp of the last event emitted for each content UUID, so it survives restarts.
+type TimestampStore interface {
+	// Load passes every persisted timestamp to add, in the order they were persisted.
+	Load(add func(contentUUID string, timestamp time.Time)) error
+	// Set persists the timestamp of the content.
+	Set(contentUUID string, timestamp time.Time) error
+	// Compact replaces all the persisted timestamps with the given ones, least recently recorded first.
+	Compact(timestamps []ContentTimestamp) error
+}
+
+// ContentTimestamp is the timestamp of the last event emitted for a content UUID.
+type ContentTimestamp struct {
+	UUID      string    `json:"uuid"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// TimestampTracker remembers the Message-Timestamp of the last event emitted for each content UUID,
+// keeping the most recently recorded ones in memory and, optionally, persisting them in a store.
+// The store is only read on creation, through the in-memory cache, and is compacted to the content of the cache
+// after as many updates as the cache size, so neither the memory nor the store grow beyond the cache size.
+type TimestampTracker struct {
+	lock  *sync.Mutex
+	cache *lru.Cache
+	size  int
+	store TimestampStore
+	// updates is the number of timestamps persisted since the store was last compacted.
+	updates int
+}
+
+// NewTimestampTracker creates a tracker keeping up to size content UUIDs in memory.
+// The store is optional. The most recently persisted timestamps are loaded from it, up to the size.
+func NewTimestampTracker(size int, store TimestampStore) (*TimestampTracker, error) {
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create timestamp cache: %w", err)
+	}
+	t := &TimestampTracker{lock: &sync.Mutex{}, cache: cache, size: size, store: store}
+	if store == nil {
+		return t, nil
+	}
+
+	err = store.Load(func(contentUUID string, timestamp time.Time) {
+		if last, found := t.cache.Peek(contentUUID); !found || timestamp.After(last.(time.Time)) {
+			t.cache.Add(contentUUID, timestamp)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = store.Compact(t.snapshot()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// LastSeen returns the timestamp of the last event emitted for the content, if any.
+func (t *TimestampTracker) LastSeen(contentUUID string) (time.Time, bool) {
+	if v, found := t.cache.Get(contentUUID); found {
+		return v.(time.Time), true
+	}
+	return time.Time{}, false
+}
+
+// Record remembers the timestamp of an emitted event, unless a later one has already been recorded.
+func (t *TimestampTracker) Record(contentUUID string, timestamp time.Time) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if last, found := t.cache.Get(contentUUID); found && last.(time.Time).After(timestamp) {
+		return nil
+	}
+
+	t.cache.Add(contentUUID, timestamp)
+	if t.store == nil {
+		return nil
+	}
+	if err := t.store.Set(contentUUID, timestamp); err != nil {
+		return err
+	}
+
+	t.updates++
+	if t.updates < t.size {
+		return nil
+	}
+	t.updates = 0
+	return t.store.Compact(t.snapshot())
+}
+
+// snapshot returns the cached timestamps, least recently recorded first, so that loading them keeps the same ones.
+func (t *TimestampTracker) snapshot() []ContentTimestamp {
+	timestamps := make([]ContentTimestamp, 0, t.cache.Len())
+	for _, key := range t.cache.Keys() {
+		if v, found := t.cache.Peek(key); found {
+			timestamps = append(timestamps, ContentTimestamp{UUID: key.(string), Timestamp: v.(time.Time)})
+		}
+	}
+	return timestamps
+}
+
+// FileTimestampStore appends every update to a file, one JSON entry per line. Compacting rewrites the file
+// with one entry per content UUID. Nothing is kept in memory.
+type FileTimestampStore struct {
+	lock *sync.Mutex
+	path string
+	file *os.File
+}
+
+// OpenFileTimestampStore opens the file for appending, creating it if it does not exist.
+func OpenFileTimestampStore(path string) (*FileTimestampStore, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open timestamp store: %w", err)
+	}
+	return &FileTimestampStore{lock: &sync.Mutex{}, path: path, file: file}, nil
+}
+
+func (s *FileTimestampStore) Load(add func(contentUUID string, timestamp time.Time)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("cannot read timestamp store: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry ContentTimestamp
+		// a partially written last line is expected after a crash and is ignored
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		add(entry.UUID, entry.Timestamp)
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("cannot read timestamp store: %w", err)
+	}
+	return nil
+}
+
+func (s *FileTimestampStore) Set(contentUUID string, timestamp time.Time) error {
+	line, err := json.Marshal(ContentTimestamp{UUID: contentUUID, Timestamp: timestamp})
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err = s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("cannot write timestamp store: %w", err)
+	}
+	return nil
+}
+
+// Compact writes the timestamps to a new file, which then replaces the store file.
+func (s *FileTimestampStore) Compact(timestamps []ContentTimestamp) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	compacted := s.path + ".tmp"
+	if err := writeTimestamps(compacted, timestamps); err != nil {
+		return err
+	}
+	if err := os.Rename(compacted, s.path); err != nil {
+		return fmt.Errorf("cannot compact timestamp store: %w", err)
+	}
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open timestamp store: %w", err)
+	}
+	s.file.Close()
+	s.file = file
+	return nil
+}
+
+func writeTimestamps(path string, timestamps []ContentTimestamp) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("cannot write timestamp store: %w", err)
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	for _, entry := range timestamps {
+		if err = enc.Encode(entry); err != nil {
+			return fmt.Errorf("cannot write timestamp store: %w", err)
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return fmt.Errorf("cannot write timestamp store: %w", err)
+	}
+	return file.Sync()
+}
+
+func (s *FileTimestampStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.file.Close()
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStalePolicy(t *testing.T) {
+	for _, s := range []string{"off", "skip", "flag"} {
+		p, err := ParseStalePolicy(s)
+		assert.NoError(t, err)
+		assert.Equal(t, StalePolicy(s), p)
+	}
+
+	_, err := ParseStalePolicy("drop")
+	assert.Error(t, err)
+}
+
+func TestTimestampTrackerKeepsLatestTimestamp(t *testing.T) {
+	tracker, err := NewTimestampTracker(10, nil)
+	require.NoError(t, err)
+
+	contentUUID := uuid.NewString()
+	_, found := tracker.LastSeen(contentUUID)
+	assert.False(t, found)
+
+	now := time.Now()
+	require.NoError(t, tracker.Record(contentUUID, now))
+	require.NoError(t, tracker.Record(contentUUID, now.Add(-time.Minute)))
+
+	lastSeen, found := tracker.LastSeen(contentUUID)
+	assert.True(t, found)
+	assert.True(t, now.Equal(lastSeen), "an older timestamp should not replace a newer one")
+}
+
+func TestTimestampTrackerLoadsStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "timestamps.jsonl")
+	store, err := OpenFileTimestampStore(path)
+	require.NoError(t, err)
+
+	tracker, err := NewTimestampTracker(2, store)
+	require.NoError(t, err)
+
+	evicted, kept, latest := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	now := time.Now().UTC()
+	require.NoError(t, tracker.Record(evicted, now))
+	require.NoError(t, tracker.Record(kept, now))
+	require.NoError(t, tracker.Record(latest, now))
+	require.NoError(t, store.Close())
+
+	store, err = OpenFileTimestampStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+	tracker, err = NewTimestampTracker(2, store)
+	require.NoError(t, err)
+
+	for _, contentUUID := range []string{kept, latest} {
+		lastSeen, found := tracker.LastSeen(contentUUID)
+		assert.True(t, found, "the most recently recorded content UUIDs should be loaded from the store")
+		assert.True(t, now.Equal(lastSeen))
+	}
+	_, found := tracker.LastSeen(evicted)
+	assert.False(t, found, "no more content UUIDs than the cache size should be loaded")
+}
+
+func TestTimestampTrackerCompactsStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "timestamps.jsonl")
+	store, err := OpenFileTimestampStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+
+	const size = 10
+	tracker, err := NewTimestampTracker(size, store)
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	for i := 0; i < 10*size; i++ {
+		require.NoError(t, tracker.Record(uuid.NewString(), now))
+		assert.LessOrEqual(t, countLines(t, path), 2*size, "the store should not grow beyond twice the cache size")
+	}
+}
+
+func TestFileTimestampStoreSurvivesReopening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "timestamps.jsonl")
+	store, err := OpenFileTimestampStore(path)
+	require.NoError(t, err)
+
+	contentUUID := uuid.NewString()
+	now := time.Now().UTC()
+	require.NoError(t, store.Set(contentUUID, now.Add(-time.Hour)))
+	require.NoError(t, store.Set(contentUUID, now))
+	require.NoError(t, store.Close())
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"uuid":"partial`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	store, err = OpenFileTimestampStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+
+	tracker, err := NewTimestampTracker(10, store)
+	require.NoError(t, err)
+	timestamp, found := tracker.LastSeen(contentUUID)
+	assert.True(t, found)
+	assert.True(t, now.Equal(timestamp))
+	assert.Equal(t, 1, countLines(t, path), "the store should be compacted when loaded")
+}
+
+func countLines(t *testing.T, path string) int {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return strings.Count(string(data), "\n")
+}
@@ -0,0 +1,21 @@
+package service
+
+import (
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/Financial-Times/pac-annotations-mapper/service"
+
+// traceContext propagates the W3C trace context through the traceparent and tracestate message headers.
+var traceContext = propagation.TraceContext{}
+
+// endSpan marks the span as failed if there is an error, then ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
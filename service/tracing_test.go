@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/v3"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func spanNames(spans []sdktrace.ReadOnlySpan) []string {
+	names := make([]string, 0, len(spans))
+	for _, s := range spans {
+		names = append(names, s.Name())
+	}
+	return names
+}
+
+func TestHandleMessageIsTraced(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	mp := &mockMessageProducer{}
+	mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+	service := NewAnnotationMapperService(whitelist, mp, log, WithTracerProvider(provider))
+
+	service.HandleMessage(kafka.FTMessage{
+		Headers: map[string]string{"Origin-System-Id": testSystemID, "X-Request-Id": testTxID, "traceparent": testTraceParent},
+		Body:    fmt.Sprintf(`{"uuid":"%s","annotations":[]}`, uuid.NewString()),
+	})
+
+	spans := recorder.Ended()
+	assert.ElementsMatch(t, []string{"FilterMessage", "UnmarshalMessage", "ValidateEvent", "MapAnnotations", "SendMessage", "HandleMessage"}, spanNames(spans))
+
+	var root, send sdktrace.ReadOnlySpan
+	for _, s := range spans {
+		switch s.Name() {
+		case "HandleMessage":
+			root = s
+		case "SendMessage":
+			send = s
+		}
+	}
+	require.NotNil(t, root)
+	require.NotNil(t, send)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", root.SpanContext().TraceID().String(), "the inbound trace should be continued")
+	assert.Equal(t, "00f067aa0ba902b7", root.Parent().SpanID().String())
+	assert.True(t, root.Parent().IsRemote())
+	assert.Equal(t, trace.SpanKindProducer, send.SpanKind())
+
+	require.Len(t, mp.received, 1)
+	outbound := traceContext.Extract(context.Background(), propagation.MapCarrier(mp.received[0].Headers))
+	outboundSpan := trace.SpanContextFromContext(outbound)
+	assert.Equal(t, root.SpanContext().TraceID(), outboundSpan.TraceID(), "the trace context should be injected into the outbound headers")
+	assert.Equal(t, send.SpanContext().SpanID(), outboundSpan.SpanID())
+}
+
+func TestHandleMessageSpanRecordsErrors(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	service := NewAnnotationMapperService(whitelist, &mockMessageProducer{}, log, WithTracerProvider(provider))
+
+	service.HandleMessage(kafka.FTMessage{
+		Headers: map[string]string{"Origin-System-Id": testSystemID, "X-Request-Id": testTxID},
+		Body:    `{"uuid":`,
+	})
+
+	spans := recorder.Ended()
+	assert.ElementsMatch(t, []string{"FilterMessage", "UnmarshalMessage", "HandleMessage"}, spanNames(spans))
+	for _, s := range spans {
+		if s.Name() == "FilterMessage" {
+			continue
+		}
+		assert.Equal(t, codes.Error, s.Status().Code, "span %s status", s.Name())
+	}
+}
+
+func TestTraceContextIsPassedOnWithoutTracing(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	whitelist := regexp.MustCompile(strings.Replace(testSystemID, ".", `\.`, -1))
+	mp := &mockMessageProducer{}
+	mp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+	service := NewAnnotationMapperService(whitelist, mp, log, WithTracerProvider(trace.NewNoopTracerProvider()))
+
+	service.HandleMessage(kafka.FTMessage{
+		Headers: map[string]string{"Origin-System-Id": testSystemID, "X-Request-Id": testTxID, "traceparent": testTraceParent},
+		Body:    fmt.Sprintf(`{"uuid":"%s","annotations":[]}`, uuid.NewString()),
+	})
+
+	require.Len(t, mp.received, 1)
+	assert.Equal(t, testTraceParent, mp.received[0].Headers["traceparent"])
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"crypto/rand"
+
+	"github.com/Financial-Times/kafka-client-go/v3"
+)
+
+// generatedTransactionIDHeader marks messages whose X-Request-Id was generated by the mapper,
+// as the consumed message had none.
+const generatedTransactionIDHeader = "X-Request-Id-Generated"
+
+const transactionIDChars = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// newTransactionID returns a random transaction id in the UPP tid_ format.
+func newTransactionID() string {
+	id := make([]byte, 10)
+	_, _ = rand.Read(id)
+	for i, b := range id {
+		id[i] = transactionIDChars[int(b)%len(transactionIDChars)]
+	}
+	return "tid_" + string(id)
+}
+
+// withGeneratedTransactionID returns a copy of the message with a generated X-Request-Id,
+// leaving the headers of the original message untouched.
+func withGeneratedTransactionID(msg kafka.FTMessage, tid string) kafka.FTMessage {
+	headers := make(map[string]string, len(msg.Headers)+2)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers["X-Request-Id"] = tid
+	headers[generatedTransactionIDHeader] = "true"
+	return kafka.FTMessage{Headers: headers, Body: msg.Body}
+}
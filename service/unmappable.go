@@ -0,0 +1,36 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+)
+
+// UnmappablePolicy decides what happens to an event with annotations none of which could be mapped,
+// as emitting it would delete all the annotations of the content downstream.
+type UnmappablePolicy string
+
+const (
+	// UnmappablePolicyEmit emits the event with an empty list of annotations.
+	UnmappablePolicyEmit UnmappablePolicy = "emit"
+	// UnmappablePolicySkip does not emit the event.
+	UnmappablePolicySkip UnmappablePolicy = "skip"
+	// UnmappablePolicyDeadLetter sends the event to the dead-letter queue instead of emitting it.
+	UnmappablePolicyDeadLetter UnmappablePolicy = "deadLetter"
+)
+
+func ParseUnmappablePolicy(s string) (UnmappablePolicy, error) {
+	switch p := UnmappablePolicy(s); p {
+	case UnmappablePolicyEmit, UnmappablePolicySkip, UnmappablePolicyDeadLetter:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown unmappable event policy %q, expected one of %s, %s or %s", s, UnmappablePolicyEmit, UnmappablePolicySkip, UnmappablePolicyDeadLetter)
+}
+
+// errNoMappableAnnotations is the dead-letter cause of events none of whose annotations could be mapped.
+var errNoMappableAnnotations = errors.New("none of the annotations could be mapped")
+
+// isUnmappable reports whether the event had annotations but all of them were dropped.
+// An event published without annotations is a genuine removal of the annotations and is never unmappable.
+func isUnmappable(event PacMetadataPublishEvent, result MappingResult) bool {
+	return len(event.Annotations) > 0 && len(result.Annotations) == 0
+}
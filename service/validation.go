@@ -0,0 +1,67 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	violationInvalidUUID           = "invalid_uuid"
+	violationInvalidPredicate      = "invalid_predicate"
+	violationInvalidConceptID      = "invalid_concept_id"
+	violationDeleteWithAnnotations = "delete_with_annotations"
+)
+
+// Violation is a single reason for rejecting a PAC metadata publish event.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every reason a PAC metadata publish event was rejected.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+	return "invalid metadata publish event: " + strings.Join(messages, "; ")
+}
+
+// validateEvent checks that the event has a valid content UUID and that all its annotations
+// have absolute predicate URIs and concept IDs which can be normalised. Delete events must have no annotations.
+func validateEvent(event PacMetadataPublishEvent) error {
+	var violations []Violation
+
+	if _, err := uuid.Parse(event.UUID); err != nil {
+		violations = append(violations, Violation{Rule: violationInvalidUUID, Message: fmt.Sprintf("uuid %q is not a valid UUID", event.UUID)})
+	}
+	if event.Deleted && len(event.Annotations) > 0 {
+		violations = append(violations, Violation{Rule: violationDeleteWithAnnotations, Message: fmt.Sprintf("delete event has %d annotations", len(event.Annotations))})
+	}
+
+	for i, ann := range event.Annotations {
+		if !isAbsoluteURI(ann.Predicate) {
+			violations = append(violations, Violation{Rule: violationInvalidPredicate, Message: fmt.Sprintf("annotation %d predicate %q is not an absolute URI", i, ann.Predicate)})
+		}
+		if _, err := normaliseConceptID(ann.ConceptId); err != nil {
+			violations = append(violations, Violation{Rule: violationInvalidConceptID, Message: fmt.Sprintf("annotation %d %v", i, err)})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
+
+func isAbsoluteURI(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.IsAbs() && u.Host != ""
+}